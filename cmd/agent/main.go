@@ -1,7 +1,12 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/rsa"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -9,27 +14,196 @@ import (
 	"time"
 
 	"github.com/akashipov/MetricCollector/internal/agent"
+	"github.com/akashipov/MetricCollector/internal/general"
 	"github.com/go-resty/resty/v2"
 )
 
-func run(wg *sync.WaitGroup, done chan bool) {
+func run(wg *sync.WaitGroup, done chan bool) *agent.MetricSender {
 	agent.ParseArgsClient()
+	cfg, err := agent.CurrentClientConfig()
+	if err != nil {
+		panic(err)
+	}
 	client := resty.New()
 	client = client.SetTimeout(2 * time.Second)
-	var m sync.Mutex
-	goPull := make(chan struct{})
-	ms := agent.MetricSender{
-		URL:                fmt.Sprintf("http://%s", *agent.HPClient),
-		ListMetrics:        &agent.ListMetrics,
+	secure := *agent.TLSCA != "" || *agent.TLSCert != "" || *agent.TLSKey != "" || *agent.TLSServerName != ""
+	if secure {
+		tlsConfig, err := general.ClientTLSConfig(*agent.TLSCA, *agent.TLSCert, *agent.TLSKey, *agent.TLSServerName)
+		if err != nil {
+			panic(err)
+		}
+		client = client.SetTLSClientConfig(tlsConfig)
+	}
+	collectors, err := newCollectors(cfg)
+	if err != nil {
+		panic(err)
+	}
+	urls := make([]string, 0)
+	for _, address := range agent.SplitList(*agent.HPClient) {
+		urls = append(urls, agent.ServerURL(address, secure))
+	}
+	if *agent.SendMode != "failover" && *agent.SendMode != "fanout" {
+		panic(fmt.Errorf("unknown send mode '%s'", *agent.SendMode))
+	}
+	servers, err := agent.NewServerPool(
+		urls, *agent.SendMode == "fanout", time.Duration(*agent.ProbeInterval)*time.Second,
+	)
+	if err != nil {
+		panic(err)
+	}
+	if *agent.GzipLevel < gzip.NoCompression || *agent.GzipLevel > gzip.BestCompression {
+		panic(fmt.Errorf("gzip level should be from 0 to 9: %d", *agent.GzipLevel))
+	}
+	var publicKey *rsa.PublicKey
+	if *agent.CryptoKey != "" {
+		publicKey, err = general.LoadPublicKey(*agent.CryptoKey)
+		if err != nil {
+			panic(err)
+		}
+	}
+	retry := general.DefaultRetryPolicy()
+	retry.MaxAttempts = *agent.RetryAttempts
+	var queue *agent.DiskQueue
+	if *agent.QueueDir != "" {
+		queue, err = agent.NewDiskQueue(*agent.QueueDir, *agent.QueueMaxSize)
+		if err != nil {
+			fmt.Printf("Queue cannot be opened, unsent batches will be lost: %s\n", err.Error())
+			queue = nil
+		}
+	}
+	buffer := agent.NewMetricBuffer()
+	buffer.SetGaugeAggregation(gaugeAggregation(cfg))
+	var telemetry *agent.Telemetry
+	if *agent.TelemetryPrefix != "" {
+		telemetry = agent.NewTelemetry(*agent.TelemetryPrefix, buffer)
+	}
+	if *agent.StatsdAddress != "" {
+		listener := agent.NewStatsdListener(*agent.StatsdAddress, buffer)
+		err = listener.Listen()
+		if err != nil {
+			panic(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listener.Serve(done)
+		}()
+	}
+	if *agent.PushAddress != "" {
+		err = agent.CheckLoopbackAddress(*agent.PushAddress)
+		if err != nil {
+			panic(err)
+		}
+		pushSrv := &http.Server{Addr: *agent.PushAddress, Handler: agent.PushRouter(buffer)}
+		go func() {
+			<-done
+			if err := pushSrv.Shutdown(context.Background()); err != nil {
+				fmt.Printf("Push server Shutdown: %v\n", err)
+			}
+		}()
+		go func() {
+			fmt.Printf("Push server is running on %s...\n", *agent.PushAddress)
+			err := pushSrv.ListenAndServe()
+			if err != http.ErrServerClosed {
+				log.Fatalf("Push server ListenAndServe: %v", err)
+			}
+		}()
+	}
+	labels, err := agent.MetricLabels(cfg.Host, cfg.Tags)
+	if err != nil {
+		panic(err)
+	}
+	filter := agent.NewFilter(cfg.MetricsInclude, cfg.MetricsExclude)
+	ms := &agent.MetricSender{
+		URL:                urls[0],
+		Servers:            servers,
 		Client:             client,
-		ReportIntervalTime: agent.ReportInterval,
-		PollIntervalTime:   agent.PollInterval,
+		ReportIntervalTime: cfg.ReportInterval,
+		RateLimit:          cfg.RateLimit,
+		Key:                cfg.Key,
+		Retry:              &retry,
+		GzipLevel:          *agent.GzipLevel,
+		GzipMinSize:        *agent.GzipMinSize,
+		PublicKey:          publicKey,
+		BreakerThreshold:   *agent.BreakerThreshold,
+		BreakerCooldown:    time.Duration(*agent.BreakerCooldown) * time.Second,
+		Queue:              queue,
+		Telemetry:          telemetry,
+		Buffer:             buffer,
+		Collectors:         collectors,
+		MetricFilter:       &filter,
+		Labels:             labels,
+		FlushTimeout:       time.Duration(*agent.ShutdownTimeout) * time.Second,
 		Done:               done,
-		M:                  &m,
-		GoPull:             goPull,
 		WG:                 wg,
 	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-hup:
+				err := reload(ms)
+				if err != nil {
+					fmt.Printf("Config has not been reloaded: %s\n", err.Error())
+					continue
+				}
+				fmt.Println("Config has been reloaded")
+			case <-done:
+				signal.Stop(hup)
+				return
+			}
+		}
+	}()
 	ms.Run()
+	return ms
+}
+
+// reload applies config file to the running sender. Collectors, their intervals and options,
+// metric filters, aggregated gauges, host and tags, key, report interval and rate limit
+// are changed. Servers and send mode, probe interval, TLS, crypto key, gzip, retry,
+// breaker, shutdown timeout, queue, telemetry, StatsD and push settings are read only on start.
+func reload(ms *agent.MetricSender) error {
+	cfg, err := agent.ReloadArgsClient()
+	if err != nil {
+		return err
+	}
+	collectors, err := newCollectors(cfg)
+	if err != nil {
+		return err
+	}
+	labels, err := agent.MetricLabels(cfg.Host, cfg.Tags)
+	if err != nil {
+		return err
+	}
+	filter := agent.NewFilter(cfg.MetricsInclude, cfg.MetricsExclude)
+	err = ms.Reload(collectors, &filter)
+	if err != nil {
+		return err
+	}
+	ms.SetLabels(labels)
+	ms.SetSendOptions(cfg.Key, cfg.ReportInterval, cfg.RateLimit)
+	ms.Buffer.SetGaugeAggregation(gaugeAggregation(cfg))
+	return nil
+}
+
+func newCollectors(cfg *agent.ClientConfig) ([]agent.ScheduledCollector, error) {
+	intervals, err := agent.ParseIntervals(cfg.CollectorIntervals)
+	if err != nil {
+		return nil, err
+	}
+	return agent.NewCollectors(cfg, agent.SplitList(cfg.Collectors), intervals, cfg.PollInterval)
+}
+
+// gaugeAggregation returns filter of gauges aggregated over report interval, nil if there are none
+func gaugeAggregation(cfg *agent.ClientConfig) *agent.Filter {
+	if len(agent.SplitList(cfg.AggregateGauges)) == 0 {
+		return nil
+	}
+	filter := agent.NewFilter(cfg.AggregateGauges, "")
+	return &filter
 }
 
 func main() {
@@ -44,9 +218,13 @@ func main() {
 		fmt.Println(sig)
 		close(done)
 		wg.Done()
+		// the second signal does not wait for the final report
+		<-sigs
+		fmt.Println("Exiting without the final report...")
+		os.Exit(1)
 	}()
 	wg.Add(1)
-	go run(&wg, done)
+	ms := run(&wg, done)
 	fmt.Println("Awaiting signal...")
 	_, isRunning := <-done
 	if isRunning {
@@ -54,4 +232,8 @@ func main() {
 	}
 	fmt.Println("Exiting...")
 	wg.Wait()
+	if err := ms.FlushErr(); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -54,6 +55,15 @@ func main() {
 	fmt.Println("exiting")
 }
 
+// snapshotRetry repeats creating of snapshot file, which can be locked for a while by backup tools
+var snapshotRetry = func() general.RetryPolicy {
+	p := general.DefaultRetryPolicy()
+	p.Retryable = func(err error) bool {
+		return errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY)
+	}
+	return p
+}()
+
 func Storage() {
 	tickerStorageInterval := time.NewTicker(time.Duration(*server.PTSave) * time.Second)
 	defer tickerStorageInterval.Stop()
@@ -61,11 +71,11 @@ func Storage() {
 		<-tickerStorageInterval.C
 		var f *os.File
 		var err error
-		fun := func() error {
+		fun := func(ctx context.Context) error {
 			f, err = os.Create(*server.FSPath)
 			return err
 		}
-		err = general.RetryCode(fun, syscall.EACCES)
+		err = snapshotRetry.Do(context.Background(), fun)
 		if err != nil {
 			fmt.Println("Create block: " + err.Error())
 			return
@@ -88,7 +98,15 @@ func Storage() {
 
 func run(srv *http.Server) {
 	server.ParseArgsServer()
-	err := server.InitDB()
+	err := server.LoadCryptoKey()
+	if err != nil {
+		panic(err)
+	}
+	err = server.LoadTrustedSubnet()
+	if err != nil {
+		panic(err)
+	}
+	err = server.InitDB()
 	if err != nil {
 		panic(err)
 	}
@@ -115,7 +133,17 @@ func run(srv *http.Server) {
 		}
 		go Storage()
 	}
-	err = srv.ListenAndServe()
+	if *server.TLSCert != "" {
+		srv.TLSConfig, err = general.ServerTLSConfig(*server.TLSCert, *server.TLSKey, *server.TLSClientCA)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("HTTPS is served, client certificates are required:", *server.TLSClientCA != "")
+		// certificate is already in TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != http.ErrServerClosed {
 		log.Fatalf("HTTP server ListenAndServe: %v", err)
 	}
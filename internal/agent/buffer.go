@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// MetricBuffer accumulates metrics between reports, metrics with different labels are kept apart.
+// Gauges keep the last value, deltas of counters are summed,
+// timings are summarized into gauges when the buffer is flushed.
+// Gauges selected by SetGaugeAggregation are reported together with
+// 'id.min', 'id.max' and 'id.mean' of all their values since the previous flush.
+type MetricBuffer struct {
+	m            sync.Mutex
+	metrics      map[string]*general.Metrics
+	order        []string
+	timings      map[string]*timing
+	timingsOrder []string
+	aggregate    *Filter
+	windows      map[string]*gaugeWindow
+	// fractions are sums of fractional increments of counters, e.g. sampled StatsD ones,
+	// they are rounded once when the buffer is flushed
+	fractions map[string]float64
+}
+
+type gaugeWindow struct {
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+type timing struct {
+	values []float64
+	count  float64
+}
+
+var timingPercentiles = []float64{50, 95, 99}
+
+func NewMetricBuffer() *MetricBuffer {
+	return &MetricBuffer{
+		metrics:   make(map[string]*general.Metrics),
+		timings:   make(map[string]*timing),
+		windows:   make(map[string]*gaugeWindow),
+		fractions: make(map[string]float64),
+	}
+}
+
+// SetGaugeAggregation selects gauges whose values are aggregated over the report window,
+// nil disables aggregation. It is applied starting from the next flush.
+func (b *MetricBuffer) SetGaugeAggregation(filter *Filter) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.aggregate = filter
+}
+
+func (b *MetricBuffer) Add(metrics ...general.Metrics) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for _, metric := range metrics {
+		key := metric.Key()
+		var stored general.Metrics
+		switch {
+		case metric.MType == COUNTER && metric.Delta != nil:
+			delta := *metric.Delta
+			val, ok := b.metrics[key]
+			if ok && val.MType == COUNTER {
+				delta += *val.Delta
+			}
+			stored = general.Metrics{ID: metric.ID, MType: COUNTER, Delta: &delta, Labels: metric.Labels}
+		case metric.MType == GAUGE && metric.Value != nil:
+			value := *metric.Value
+			stored = general.Metrics{ID: metric.ID, MType: GAUGE, Value: &value, Labels: metric.Labels}
+			if b.aggregate != nil && b.aggregate.Match(metric.ID) {
+				b.observe(key, value)
+			}
+		default:
+			continue
+		}
+		if _, ok := b.metrics[key]; !ok {
+			b.order = append(b.order, key)
+		}
+		b.metrics[key] = &stored
+	}
+}
+
+func (b *MetricBuffer) observe(key string, value float64) {
+	w, ok := b.windows[key]
+	if !ok {
+		b.windows[key] = &gaugeWindow{min: value, max: value, sum: value, count: 1}
+		return
+	}
+	if value < w.min {
+		w.min = value
+	}
+	if value > w.max {
+		w.max = value
+	}
+	w.sum += value
+	w.count++
+}
+
+// AddCount increases counter by value which is not integer, e.g. count of sampled events
+// scaled by their sample rate. Increments are summed and rounded when the buffer is flushed.
+func (b *MetricBuffer) AddCount(id string, value float64) {
+	var delta int64
+	metric := general.Metrics{ID: id, MType: COUNTER, Delta: &delta}
+	// the key is the same as the one of counters added by Add, so they are summed
+	key := metric.Key()
+	b.m.Lock()
+	defer b.m.Unlock()
+	val, ok := b.metrics[key]
+	if !ok || val.MType != COUNTER {
+		if !ok {
+			b.order = append(b.order, key)
+		}
+		b.metrics[key] = &metric
+	}
+	b.fractions[key] += value
+}
+
+// AddTiming records one measurement of duration or another distribution.
+// rate is a sample rate of the measurement in (0, 1], so it counts as 1/rate measurements.
+func (b *MetricBuffer) AddTiming(id string, value float64, rate float64) {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	t, ok := b.timings[id]
+	if !ok {
+		t = &timing{}
+		b.timings[id] = t
+		b.timingsOrder = append(b.timingsOrder, id)
+	}
+	t.values = append(t.values, value)
+	t.count += 1 / rate
+}
+
+func (b *MetricBuffer) Len() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return len(b.metrics) + len(b.timings)
+}
+
+// Flush returns accumulated metrics in order of their first appearance and empties the buffer
+func (b *MetricBuffer) Flush() []general.Metrics {
+	return b.FlushMatching(nil)
+}
+
+// FlushMatching is Flush which drops metrics with names not matching filter, all are returned
+// if it is nil. Aggregates of gauges and summaries of timings are matched by the name of gauge
+// or timing they are made of, so they are kept or dropped together with it.
+func (b *MetricBuffer) FlushMatching(filter *Filter) []general.Metrics {
+	b.m.Lock()
+	defer b.m.Unlock()
+	metrics := make([]general.Metrics, 0, len(b.order))
+	for _, key := range b.order {
+		metric := *b.metrics[key]
+		if filter != nil && !filter.Match(metric.ID) {
+			continue
+		}
+		if f, ok := b.fractions[key]; ok && metric.MType == COUNTER {
+			delta := *metric.Delta + int64(math.Round(f))
+			metric.Delta = &delta
+		}
+		metrics = append(metrics, metric)
+		// gauge can be replaced by counter with the same name, then there is nothing to aggregate
+		if w, ok := b.windows[key]; ok && metric.MType == GAUGE {
+			metrics = append(
+				metrics,
+				labeled(gauge(metric.ID+".min", w.min), metric.Labels),
+				labeled(gauge(metric.ID+".max", w.max), metric.Labels),
+				labeled(gauge(metric.ID+".mean", w.sum/float64(w.count)), metric.Labels),
+			)
+		}
+	}
+	for _, id := range b.timingsOrder {
+		if filter != nil && !filter.Match(id) {
+			continue
+		}
+		metrics = append(metrics, b.timings[id].summary(id)...)
+	}
+	b.metrics = make(map[string]*general.Metrics)
+	b.order = nil
+	b.timings = make(map[string]*timing)
+	b.timingsOrder = nil
+	b.windows = make(map[string]*gaugeWindow)
+	b.fractions = make(map[string]float64)
+	return metrics
+}
+
+// summary returns gauges 'id.count', 'id.min', 'id.max', 'id.mean' and 'id.p<N>' for every percentile
+func (t *timing) summary(id string) []general.Metrics {
+	values := t.values
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	metrics := []general.Metrics{
+		gauge(id+".count", t.count),
+		gauge(id+".min", values[0]),
+		gauge(id+".max", values[len(values)-1]),
+		gauge(id+".mean", sum/float64(len(values))),
+	}
+	for _, p := range timingPercentiles {
+		// nearest-rank method
+		rank := int(math.Ceil(p / 100 * float64(len(values))))
+		if rank < 1 {
+			rank = 1
+		}
+		metrics = append(metrics, gauge(fmt.Sprintf("%s.p%g", id, p), values[rank-1]))
+	}
+	return metrics
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricBuffer_Flush(t *testing.T) {
+	buffer := NewMetricBuffer()
+	a, b := int64(3), int64(4)
+	x, y := 1.5, 2.5
+	buffer.Add(
+		general.Metrics{ID: "Requests", MType: COUNTER, Delta: &a},
+		general.Metrics{ID: "Temperature", MType: GAUGE, Value: &x},
+		general.Metrics{ID: "Broken", MType: GAUGE},
+	)
+	buffer.Add(
+		general.Metrics{ID: "Temperature", MType: GAUGE, Value: &y},
+		general.Metrics{ID: "Requests", MType: COUNTER, Delta: &b},
+	)
+	// values passed to the buffer are copied
+	a, y = 100, 100
+	assert.Equal(t, 2, buffer.Len())
+	metrics := buffer.Flush()
+	assert.Equal(t, 2, len(metrics))
+	assert.Equal(t, "Requests", metrics[0].ID)
+	assert.Equal(t, int64(7), *metrics[0].Delta)
+	assert.Equal(t, "Temperature", metrics[1].ID)
+	assert.Equal(t, 2.5, *metrics[1].Value)
+	assert.Equal(t, 0, buffer.Len())
+	assert.Empty(t, buffer.Flush())
+}
+
+func TestMetricBuffer_GaugeAggregation(t *testing.T) {
+	buffer := NewMetricBuffer()
+	filter := NewFilter("Heap*", "")
+	buffer.SetGaugeAggregation(&filter)
+	for _, v := range []float64{10, 40, 20, 30} {
+		buffer.Add(gauge("HeapAlloc", v), gauge("Sys", v))
+	}
+	buffer.Add(counter("PollCount", 4))
+	got := metricsToMap(buffer.Flush())
+	assert.Equal(t, 6, len(got))
+	assert.Equal(t, 30.0, *got["HeapAlloc"].Value)
+	assert.Equal(t, 10.0, *got["HeapAlloc.min"].Value)
+	assert.Equal(t, 40.0, *got["HeapAlloc.max"].Value)
+	assert.Equal(t, 25.0, *got["HeapAlloc.mean"].Value)
+	assert.Equal(t, 30.0, *got["Sys"].Value)
+	assert.NotContains(t, got, "Sys.max")
+
+	// window starts again after flush
+	buffer.Add(gauge("HeapAlloc", 5))
+	got = metricsToMap(buffer.Flush())
+	assert.Equal(t, 5.0, *got["HeapAlloc.min"].Value)
+	assert.Equal(t, 5.0, *got["HeapAlloc.max"].Value)
+
+	buffer.SetGaugeAggregation(nil)
+	buffer.Add(gauge("HeapAlloc", 5))
+	assert.Equal(t, 1, len(buffer.Flush()))
+}
+
+func TestMetricSender_PrepareBatchFilterAggregates(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		want    []string
+	}{
+		{
+			name:    "include",
+			include: "HeapAlloc,latency",
+			want: []string{
+				"HeapAlloc", "HeapAlloc.min", "HeapAlloc.max", "HeapAlloc.mean",
+				"latency.count", "latency.min", "latency.max", "latency.mean",
+				"latency.p50", "latency.p95", "latency.p99",
+			},
+		},
+		{
+			name:    "exclude",
+			exclude: "HeapAlloc,latency",
+			want:    []string{"Sys", "Sys.min", "Sys.max", "Sys.mean"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := NewMetricBuffer()
+			aggregate := NewFilter("", "")
+			buffer.SetGaugeAggregation(&aggregate)
+			buffer.Add(gauge("HeapAlloc", 1), gauge("Sys", 2))
+			buffer.AddTiming("latency", 3, 1)
+			filter := NewFilter(tt.include, tt.exclude)
+			r := MetricSender{Buffer: buffer, MetricFilter: &filter}
+			got := make([]string, 0)
+			for _, metric := range r.PrepareBatch() {
+				got = append(got, metric.ID)
+			}
+			// aggregates are kept or dropped together with the gauge or timing they are made of
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
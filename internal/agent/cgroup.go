@@ -0,0 +1,399 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// cgroupV1Unlimited is a limit starting from which cgroup v1 values mean 'no limit',
+// the kernel reports the max page-aligned int64 there
+const cgroupV1Unlimited = 1 << 62
+
+// CgroupCollector reports resources of the container the agent runs in, read from cgroup
+// files under Root. Both cgroup v2 (unified hierarchy, detected by 'cgroup.controllers')
+// and v1 (a directory per controller) are supported.
+//
+// Gauges: CgroupMemoryUsage, CgroupMemoryLimit and CgroupMemoryUtilization (percents),
+// CgroupCPULimit (cores), CgroupPids and CgroupPidsLimit. Limits are not reported if there are none.
+// Counters are deltas since the previous call: CgroupCPUUsage and CgroupCPUThrottledTime
+// (microseconds), CgroupCPUPeriods, CgroupCPUThrottledPeriods, and I/O of block devices
+// CgroupIOReadBytes, CgroupIOWriteBytes, CgroupIOReadOps and CgroupIOWriteOps labeled by 'device'
+// like '8:0'.
+// Files of controllers which are not enabled are skipped.
+type CgroupCollector struct {
+	Root     string
+	counters *counterTracker
+}
+
+func init() {
+	RegisterCollector("cgroup", func(cfg *ClientConfig) (Collector, error) {
+		return NewCgroupCollector(cfg.CgroupRoot), nil
+	})
+}
+
+func NewCgroupCollector(root string) *CgroupCollector {
+	return &CgroupCollector{Root: root, counters: newCounterTracker()}
+}
+
+func (c *CgroupCollector) Name() string {
+	return "cgroup"
+}
+
+func (c *CgroupCollector) Inherit(old Collector) {
+	if old, ok := old.(*CgroupCollector); ok && old.Root == c.Root {
+		c.counters = old.counters
+	}
+}
+
+// cgroupStats are values read from cgroup files, nil values are not available
+type cgroupStats struct {
+	memoryUsage      *uint64
+	memoryLimit      *uint64
+	cpuQuota         *float64
+	pids             *uint64
+	pidsLimit        *uint64
+	cpuUsage         *uint64
+	cpuPeriods       *uint64
+	cpuThrottled     *uint64
+	cpuThrottledTime *uint64
+	io               []cgroupDeviceIO
+}
+
+type cgroupDeviceIO struct {
+	device     string
+	readBytes  uint64
+	writeBytes uint64
+	readOps    uint64
+	writeOps   uint64
+}
+
+func (c *CgroupCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	var stats cgroupStats
+	var err error
+	if _, statErr := os.Stat(filepath.Join(c.Root, "cgroup.controllers")); statErr == nil {
+		err = c.readV2(&stats)
+	} else {
+		err = c.readV1(&stats)
+	}
+	if stats.memoryUsage == nil && stats.cpuUsage == nil && stats.pids == nil && stats.io == nil {
+		return nil, errors.Join(fmt.Errorf("there are no cgroup files in '%s'", c.Root), err)
+	}
+	metrics := make([]general.Metrics, 0)
+	if stats.memoryUsage != nil {
+		metrics = append(metrics, gauge("CgroupMemoryUsage", float64(*stats.memoryUsage)))
+		if stats.memoryLimit != nil && *stats.memoryLimit > 0 {
+			metrics = append(
+				metrics,
+				gauge("CgroupMemoryLimit", float64(*stats.memoryLimit)),
+				gauge("CgroupMemoryUtilization", float64(*stats.memoryUsage)/float64(*stats.memoryLimit)*100),
+			)
+		}
+	}
+	if stats.cpuQuota != nil {
+		metrics = append(metrics, gauge("CgroupCPULimit", *stats.cpuQuota))
+	}
+	if stats.pids != nil {
+		metrics = append(metrics, gauge("CgroupPids", float64(*stats.pids)))
+	}
+	if stats.pidsLimit != nil {
+		metrics = append(metrics, gauge("CgroupPidsLimit", float64(*stats.pidsLimit)))
+	}
+	type value struct {
+		id     string
+		value  *uint64
+		device string
+	}
+	values := []value{
+		{"CgroupCPUUsage", stats.cpuUsage, ""},
+		{"CgroupCPUPeriods", stats.cpuPeriods, ""},
+		{"CgroupCPUThrottledPeriods", stats.cpuThrottled, ""},
+		{"CgroupCPUThrottledTime", stats.cpuThrottledTime, ""},
+	}
+	for _, d := range stats.io {
+		readBytes, writeBytes, readOps, writeOps := d.readBytes, d.writeBytes, d.readOps, d.writeOps
+		values = append(
+			values,
+			value{"CgroupIOReadBytes", &readBytes, d.device},
+			value{"CgroupIOWriteBytes", &writeBytes, d.device},
+			value{"CgroupIOReadOps", &readOps, d.device},
+			value{"CgroupIOWriteOps", &writeOps, d.device},
+		)
+	}
+	for _, v := range values {
+		if v.value == nil {
+			continue
+		}
+		key := v.id
+		var labels map[string]string
+		if v.device != "" {
+			key += ":" + v.device
+			labels = map[string]string{DiskDeviceLabel: v.device}
+		}
+		if delta, ok := c.counters.Delta(key, *v.value); ok {
+			metrics = append(metrics, labeled(counter(v.id, delta), labels))
+		}
+	}
+	return metrics, err
+}
+
+// readV2 reads files of unified hierarchy
+func (c *CgroupCollector) readV2(stats *cgroupStats) error {
+	var rErr error
+	collect := func(err error) {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			rErr = errors.Join(rErr, err)
+		}
+	}
+	var err error
+	stats.memoryUsage, err = readCgroupValue(filepath.Join(c.Root, "memory.current"))
+	collect(err)
+	stats.memoryLimit, err = readCgroupValue(filepath.Join(c.Root, "memory.max"))
+	collect(err)
+	stats.pids, err = readCgroupValue(filepath.Join(c.Root, "pids.current"))
+	collect(err)
+	stats.pidsLimit, err = readCgroupValue(filepath.Join(c.Root, "pids.max"))
+	collect(err)
+	stats.cpuQuota, err = readCPUMax(filepath.Join(c.Root, "cpu.max"))
+	collect(err)
+	cpuStat, err := readCgroupKeyValues(filepath.Join(c.Root, "cpu.stat"))
+	collect(err)
+	stats.cpuUsage = cpuStat["usage_usec"]
+	stats.cpuPeriods = cpuStat["nr_periods"]
+	stats.cpuThrottled = cpuStat["nr_throttled"]
+	stats.cpuThrottledTime = cpuStat["throttled_usec"]
+	stats.io, err = readIOStat(filepath.Join(c.Root, "io.stat"))
+	collect(err)
+	return rErr
+}
+
+// readV1 reads files of controllers mounted to separate directories
+func (c *CgroupCollector) readV1(stats *cgroupStats) error {
+	var rErr error
+	collect := func(err error) {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			rErr = errors.Join(rErr, err)
+		}
+	}
+	var err error
+	stats.memoryUsage, err = readCgroupValue(filepath.Join(c.Root, "memory", "memory.usage_in_bytes"))
+	collect(err)
+	stats.memoryLimit, err = readCgroupValue(filepath.Join(c.Root, "memory", "memory.limit_in_bytes"))
+	collect(err)
+	if stats.memoryLimit != nil && *stats.memoryLimit >= cgroupV1Unlimited {
+		stats.memoryLimit = nil
+	}
+	stats.pids, err = readCgroupValue(filepath.Join(c.Root, "pids", "pids.current"))
+	collect(err)
+	stats.pidsLimit, err = readCgroupValue(filepath.Join(c.Root, "pids", "pids.max"))
+	collect(err)
+	// cpu and cpuacct are usually mounted together, 'cpu' is a link to 'cpu,cpuacct'
+	cpuDir := filepath.Join(c.Root, "cpu")
+	quota, err := readCgroupInt(filepath.Join(cpuDir, "cpu.cfs_quota_us"))
+	collect(err)
+	period, err := readCgroupInt(filepath.Join(cpuDir, "cpu.cfs_period_us"))
+	collect(err)
+	if quota != nil && period != nil && *quota > 0 && *period > 0 {
+		cores := float64(*quota) / float64(*period)
+		stats.cpuQuota = &cores
+	}
+	cpuStat, err := readCgroupKeyValues(filepath.Join(cpuDir, "cpu.stat"))
+	collect(err)
+	stats.cpuPeriods = cpuStat["nr_periods"]
+	stats.cpuThrottled = cpuStat["nr_throttled"]
+	stats.cpuThrottledTime = microseconds(cpuStat["throttled_time"])
+	usage, err := readCgroupValue(filepath.Join(c.Root, "cpuacct", "cpuacct.usage"))
+	collect(err)
+	stats.cpuUsage = microseconds(usage)
+	stats.io, err = readBlkio(filepath.Join(c.Root, "blkio"))
+	collect(err)
+	return rErr
+}
+
+// microseconds converts nanoseconds of cgroup v1 to microseconds of cgroup v2
+func microseconds(ns *uint64) *uint64 {
+	if ns == nil {
+		return nil
+	}
+	us := *ns / 1000
+	return &us
+}
+
+func readCgroupFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readCgroupValue reads file with a single number, nil is returned for 'max' which means no limit
+func readCgroupValue(path string) (*uint64, error) {
+	s, err := readCgroupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if s == "max" {
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("wrong value of '%s': %w", path, err)
+	}
+	return &v, nil
+}
+
+// readCgroupInt reads file with a single number which can be negative, like -1 quota of cgroup v1
+func readCgroupInt(path string) (*int64, error) {
+	s, err := readCgroupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("wrong value of '%s': %w", path, err)
+	}
+	return &v, nil
+}
+
+// readCPUMax reads '<quota> <period>' of cgroup v2 and returns quota in cores, nil if it is 'max'
+func readCPUMax(path string) (*float64, error) {
+	s, err := readCgroupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("wrong format of '%s': '%s'", path, s)
+	}
+	if fields[0] == "max" {
+		return nil, nil
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("wrong quota of '%s': %w", path, err)
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("wrong period of '%s': '%s'", path, fields[1])
+	}
+	cores := quota / period
+	return &cores, nil
+}
+
+// readCgroupKeyValues reads lines '<key> <value>' like in cpu.stat
+func readCgroupKeyValues(path string) (map[string]*uint64, error) {
+	values := make(map[string]*uint64)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return values, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return values, fmt.Errorf("wrong value of '%s' in '%s': %w", fields[0], path, err)
+		}
+		values[fields[0]] = &v
+	}
+	return values, nil
+}
+
+// readIOStat reads lines '<major>:<minor> rbytes=1 wbytes=2 rios=3 wios=4 ...' of cgroup v2
+func readIOStat(path string) ([]cgroupDeviceIO, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]cgroupDeviceIO, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		d := cgroupDeviceIO{device: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("wrong value of '%s' in '%s': %w", key, path, err)
+			}
+			switch key {
+			case "rbytes":
+				d.readBytes = v
+			case "wbytes":
+				d.writeBytes = v
+			case "rios":
+				d.readOps = v
+			case "wios":
+				d.writeOps = v
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// readBlkio reads 'blkio.throttle.io_service_bytes' and 'blkio.throttle.io_serviced' of cgroup v1
+// with lines '<major>:<minor> <Read|Write|...> <value>'
+func readBlkio(dir string) ([]cgroupDeviceIO, error) {
+	byDevice := make(map[string]*cgroupDeviceIO)
+	order := make([]string, 0)
+	for _, file := range []string{"blkio.throttle.io_service_bytes", "blkio.throttle.io_serviced"} {
+		path := filepath.Join(dir, file)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				// 'Total <value>' line
+				continue
+			}
+			v, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("wrong value of '%s' in '%s': %w", fields[0], path, err)
+			}
+			d, ok := byDevice[fields[0]]
+			if !ok {
+				d = &cgroupDeviceIO{device: fields[0]}
+				byDevice[fields[0]] = d
+				order = append(order, fields[0])
+			}
+			isBytes := file == "blkio.throttle.io_service_bytes"
+			switch {
+			case fields[1] == "Read" && isBytes:
+				d.readBytes = v
+			case fields[1] == "Write" && isBytes:
+				d.writeBytes = v
+			case fields[1] == "Read":
+				d.readOps = v
+			case fields[1] == "Write":
+				d.writeOps = v
+			}
+		}
+	}
+	devices := make([]cgroupDeviceIO, 0, len(order))
+	for _, device := range order {
+		devices = append(devices, *byDevice[device])
+	}
+	return devices, nil
+}
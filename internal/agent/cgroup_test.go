@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFixture writes files of cgroup fixture, keys are paths relative to root
+func writeFixture(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
+
+func TestCgroupCollector_V2(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"cgroup.controllers": "cpu io memory pids\n",
+		"memory.current":     "104857600\n",
+		"memory.max":         "209715200\n",
+		"pids.current":       "12\n",
+		"pids.max":           "max\n",
+		"cpu.max":            "150000 100000\n",
+		"cpu.stat":           "usage_usec 1000000\nuser_usec 600000\nsystem_usec 400000\nnr_periods 100\nnr_throttled 10\nthrottled_usec 50000\n",
+		"io.stat":            "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n",
+	})
+	c := NewCgroupCollector(root)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	got := metricsToMap(metrics)
+	assert.Equal(t, 104857600.0, *got["CgroupMemoryUsage"].Value)
+	assert.Equal(t, 209715200.0, *got["CgroupMemoryLimit"].Value)
+	assert.Equal(t, 50.0, *got["CgroupMemoryUtilization"].Value)
+	assert.Equal(t, 1.5, *got["CgroupCPULimit"].Value)
+	assert.Equal(t, 12.0, *got["CgroupPids"].Value)
+	assert.NotContains(t, got, "CgroupPidsLimit")
+	// counters are reported starting from the second call
+	assert.NotContains(t, got, "CgroupCPUUsage")
+
+	writeFixture(t, root, map[string]string{
+		"memory.max": "max\n",
+		"cpu.max":    "max 100000\n",
+		"cpu.stat":   "usage_usec 1500000\nnr_periods 150\nnr_throttled 13\nthrottled_usec 80000\n",
+		"io.stat":    "8:0 rbytes=6144 wbytes=8192 rios=3 wios=2\n8:16 rbytes=10 wbytes=20 rios=1 wios=1\n",
+	})
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	got = metricsToMap(metrics)
+	assert.NotContains(t, got, "CgroupMemoryLimit")
+	assert.NotContains(t, got, "CgroupMemoryUtilization")
+	assert.NotContains(t, got, "CgroupCPULimit")
+	wantCounters := map[string]int64{
+		"CgroupCPUUsage":                 500000,
+		"CgroupCPUPeriods":               50,
+		"CgroupCPUThrottledPeriods":      3,
+		"CgroupCPUThrottledTime":         30000,
+		"CgroupIOReadBytes{device=8:0}":  2048,
+		"CgroupIOWriteBytes{device=8:0}": 0,
+		"CgroupIOReadOps{device=8:0}":    2,
+		"CgroupIOWriteOps{device=8:0}":   0,
+	}
+	for id, delta := range wantCounters {
+		require.Contains(t, got, id)
+		assert.Equal(t, COUNTER, got[id].MType)
+		assert.Equal(t, delta, *got[id].Delta, id)
+	}
+	// new device is reported starting from the next call
+	assert.NotContains(t, got, "CgroupIOReadBytes{device=8:16}")
+}
+
+func TestCgroupCollector_V1(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"memory/memory.usage_in_bytes":          "52428800\n",
+		"memory/memory.limit_in_bytes":          "9223372036854771712\n",
+		"pids/pids.current":                     "5\n",
+		"pids/pids.max":                         "100\n",
+		"cpu/cpu.cfs_quota_us":                  "50000\n",
+		"cpu/cpu.cfs_period_us":                 "100000\n",
+		"cpu/cpu.stat":                          "nr_periods 10\nnr_throttled 1\nthrottled_time 2000000\n",
+		"cpuacct/cpuacct.usage":                 "3000000000\n",
+		"blkio/blkio.throttle.io_service_bytes": "8:0 Read 100\n8:0 Write 200\n8:0 Sync 300\n8:0 Total 300\nTotal 300\n",
+		"blkio/blkio.throttle.io_serviced":      "8:0 Read 1\n8:0 Write 2\nTotal 3\n",
+	})
+	c := NewCgroupCollector(root)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	got := metricsToMap(metrics)
+	assert.Equal(t, 52428800.0, *got["CgroupMemoryUsage"].Value)
+	// huge limit of cgroup v1 means no limit
+	assert.NotContains(t, got, "CgroupMemoryLimit")
+	assert.Equal(t, 0.5, *got["CgroupCPULimit"].Value)
+	assert.Equal(t, 5.0, *got["CgroupPids"].Value)
+	assert.Equal(t, 100.0, *got["CgroupPidsLimit"].Value)
+
+	writeFixture(t, root, map[string]string{
+		"memory/memory.limit_in_bytes":          "104857600\n",
+		"cpu/cpu.cfs_quota_us":                  "-1\n",
+		"cpu/cpu.stat":                          "nr_periods 20\nnr_throttled 4\nthrottled_time 5000000\n",
+		"cpuacct/cpuacct.usage":                 "3500000000\n",
+		"blkio/blkio.throttle.io_service_bytes": "8:0 Read 150\n8:0 Write 260\nTotal 410\n",
+		"blkio/blkio.throttle.io_serviced":      "8:0 Read 2\n8:0 Write 5\nTotal 7\n",
+	})
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	got = metricsToMap(metrics)
+	assert.Equal(t, 104857600.0, *got["CgroupMemoryLimit"].Value)
+	assert.Equal(t, 50.0, *got["CgroupMemoryUtilization"].Value)
+	assert.NotContains(t, got, "CgroupCPULimit")
+	wantCounters := map[string]int64{
+		"CgroupCPUUsage":                 500000,
+		"CgroupCPUPeriods":               10,
+		"CgroupCPUThrottledPeriods":      3,
+		"CgroupCPUThrottledTime":         3000,
+		"CgroupIOReadBytes{device=8:0}":  50,
+		"CgroupIOWriteBytes{device=8:0}": 60,
+		"CgroupIOReadOps{device=8:0}":    1,
+		"CgroupIOWriteOps{device=8:0}":   3,
+	}
+	for id, delta := range wantCounters {
+		require.Contains(t, got, id)
+		assert.Equal(t, delta, *got[id].Delta, id)
+	}
+}
+
+func TestCgroupCollector_Errors(t *testing.T) {
+	_, err := NewCgroupCollector(t.TempDir()).Collect(context.Background())
+	assert.Error(t, err)
+
+	// broken file is reported, the others are collected
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"cgroup.controllers": "memory pids\n",
+		"memory.current":     "1024\n",
+		"pids.current":       "many\n",
+	})
+	metrics, err := NewCgroupCollector(root).Collect(context.Background())
+	assert.Error(t, err)
+	got := metricsToMap(metrics)
+	assert.Equal(t, 1024.0, *got["CgroupMemoryUsage"].Value)
+	assert.NotContains(t, got, "CgroupPids")
+}
+
+func TestCgroupCollector_Inherit(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"cgroup.controllers": "cpu\n",
+		"cpu.stat":           "usage_usec 100\n",
+	})
+	old := NewCgroupCollector(root)
+	_, err := old.Collect(context.Background())
+	require.NoError(t, err)
+	writeFixture(t, root, map[string]string{"cpu.stat": "usage_usec 150\n"})
+	c := NewCgroupCollector(root)
+	c.Inherit(old)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), *metricsToMap(metrics)["CgroupCPUUsage"].Delta)
+}
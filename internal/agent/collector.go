@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// Collector is a source of metrics polled by MetricSender.
+// Gauges returned by Collect replace the previous values till the next report,
+// counters have to be deltas since the previous call.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]general.Metrics, error)
+}
+
+// StatefulCollector keeps state between calls of Collect, e.g. previous values of counters.
+// When collectors are recreated on reload of config, Inherit of the new one is called
+// with the stopped one of the same name, so the state is not lost.
+type StatefulCollector interface {
+	Collector
+	Inherit(old Collector)
+}
+
+// closeCollector releases resources of collector which implements io.Closer, e.g. files it
+// follows, it is called when collector is removed on reload or the sender is stopped
+func closeCollector(c Collector) {
+	closer, ok := c.(io.Closer)
+	if !ok {
+		return
+	}
+	err := closer.Close()
+	if err != nil {
+		fmt.Printf("Collector '%s' cannot be closed: %s\n", c.Name(), err.Error())
+	}
+}
+
+// CollectorFactory creates a collector with options of cfg, it is called on start and on reload
+type CollectorFactory func(cfg *ClientConfig) (Collector, error)
+
+var registryM sync.Mutex
+var registry = make(map[string]CollectorFactory)
+
+// RegisterCollector makes collector available by name for NewCollectors,
+// it is supposed to be called from init functions
+func RegisterCollector(name string, factory CollectorFactory) {
+	registryM.Lock()
+	defer registryM.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Errorf("collector '%s' is already registered", name))
+	}
+	registry[name] = factory
+}
+
+// RegisteredCollectors returns sorted names of all known collectors
+func RegisteredCollectors() []string {
+	registryM.Lock()
+	defer registryM.Unlock()
+	return registeredNames()
+}
+
+// ScheduledCollector is a collector together with interval of its polling
+type ScheduledCollector struct {
+	Collector Collector
+	Interval  time.Duration
+}
+
+// NewCollectors creates registered collectors by names with options of cfg. Interval of every
+// collector is taken from intervals in seconds, defaultInterval is used if there is no value for it.
+func NewCollectors(
+	cfg *ClientConfig, names []string, intervals map[string]int, defaultInterval int,
+) ([]ScheduledCollector, error) {
+	registryM.Lock()
+	defer registryM.Unlock()
+	for name := range intervals {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("interval is set for unknown collector '%s'", name)
+		}
+	}
+	collectors := make([]ScheduledCollector, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector '%s', known ones: %s", name, strings.Join(registeredNames(), ","))
+		}
+		interval, ok := intervals[name]
+		if !ok {
+			interval = defaultInterval
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval of collector '%s' should be positive: %d", name, interval)
+		}
+		c, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("collector '%s' cannot be created: %w", name, err)
+		}
+		collectors = append(
+			collectors,
+			ScheduledCollector{Collector: c, Interval: time.Duration(interval) * time.Second},
+		)
+	}
+	return collectors, nil
+}
+
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SplitList splits comma separated list dropping empty items
+func SplitList(s string) []string {
+	result := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// ParseIntervals parses intervals in format 'name=seconds,name=seconds'
+func ParseIntervals(s string) (map[string]int, error) {
+	intervals := make(map[string]int)
+	for _, v := range SplitList(s) {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("interval should be in format <name>=<seconds>: '%s'", v)
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("wrong interval of '%s': %w", name, err)
+		}
+		intervals[strings.TrimSpace(name)] = seconds
+	}
+	return intervals, nil
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCollector struct {
+	name    string
+	metrics []general.Metrics
+	err     error
+	panics  bool
+}
+
+func (c *fakeCollector) Name() string {
+	return c.name
+}
+
+func (c *fakeCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	if c.panics {
+		panic("something is broken")
+	}
+	return c.metrics, c.err
+}
+
+func TestNewCollectors(t *testing.T) {
+	assert.Subset(t, RegisteredCollectors(), []string{"runtime", "memory", "cpu", "disk", "network"})
+	tests := []struct {
+		name          string
+		names         []string
+		intervals     string
+		wantNames     []string
+		wantIntervals []time.Duration
+		wantErr       bool
+	}{
+		{
+			name:          "default_interval",
+			names:         []string{"runtime", "memory"},
+			wantNames:     []string{"runtime", "memory"},
+			wantIntervals: []time.Duration{2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:          "own_interval_and_duplicates",
+			names:         []string{"cpu", "memory", "cpu"},
+			intervals:     "cpu=5, memory = 1",
+			wantNames:     []string{"cpu", "memory"},
+			wantIntervals: []time.Duration{5 * time.Second, time.Second},
+		},
+		{
+			name:    "unknown_collector",
+			names:   []string{"runtime", "unknown"},
+			wantErr: true,
+		},
+		{
+			name:      "interval_of_unknown_collector",
+			names:     []string{"runtime"},
+			intervals: "unknown=1",
+			wantErr:   true,
+		},
+		{
+			name:      "wrong_interval",
+			names:     []string{"runtime"},
+			intervals: "runtime=0",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intervals, err := ParseIntervals(tt.intervals)
+			require.NoError(t, err)
+			collectors, err := NewCollectors(&ClientConfig{}, tt.names, intervals, 2)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, len(tt.wantNames), len(collectors))
+			for i, c := range collectors {
+				assert.Equal(t, tt.wantNames[i], c.Collector.Name())
+				assert.Equal(t, tt.wantIntervals[i], c.Interval)
+			}
+		})
+	}
+	_, err := ParseIntervals("cpu:5")
+	assert.Error(t, err)
+	_, err = ParseIntervals("cpu=five")
+	assert.Error(t, err)
+}
+
+func TestMetricSender_CollectIsolation(t *testing.T) {
+	r := MetricSender{Buffer: NewMetricBuffer()}
+	collectors := []Collector{
+		&fakeCollector{name: "panics", panics: true},
+		&fakeCollector{name: "fails", err: errors.New("cannot read")},
+		&fakeCollector{
+			name:    "partially_fails",
+			metrics: []general.Metrics{gauge("A", 1)},
+			err:     errors.New("cannot read B"),
+		},
+		&fakeCollector{name: "works", metrics: []general.Metrics{counter("C", 2)}},
+	}
+	var wg sync.WaitGroup
+	for _, c := range collectors {
+		wg.Add(1)
+		go func(c Collector) {
+			defer wg.Done()
+			r.Collect(context.Background(), c)
+		}(c)
+	}
+	wg.Wait()
+	got := metricsToMap(r.Buffer.Flush())
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, 1.0, *got["A"].Value)
+	assert.Equal(t, int64(2), *got["C"].Delta)
+}
@@ -0,0 +1,26 @@
+package agent
+
+// counterTracker turns cumulative values, e.g. bytes read from a device since boot,
+// into deltas since the previous observation
+type counterTracker struct {
+	prev map[string]uint64
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{prev: make(map[string]uint64)}
+}
+
+// Delta returns increase of the value since the previous call with the same key.
+// ok is false on the first call for the key, because there is nothing to compare with.
+// If the value has decreased, the source was reset and the whole value is the increase.
+func (c *counterTracker) Delta(key string, cur uint64) (delta int64, ok bool) {
+	prev, ok := c.prev[key]
+	c.prev[key] = cur
+	if !ok {
+		return 0, false
+	}
+	if cur < prev {
+		return int64(cur), true
+	}
+	return int64(cur - prev), true
+}
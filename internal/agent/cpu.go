@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+// CPUCollector computes utilization of every logical core and of all of them together
+// over the time passed since the previous call. The first call returns utilization since boot.
+type CPUCollector struct {
+	prev  []cpu.TimesStat
+	times func(ctx context.Context, percpu bool) ([]cpu.TimesStat, error)
+}
+
+func init() {
+	RegisterCollector("cpu", func(cfg *ClientConfig) (Collector, error) {
+		return NewCPUCollector(), nil
+	})
+}
+
+func NewCPUCollector() *CPUCollector {
+	return &CPUCollector{times: cpu.TimesWithContext}
+}
+
+func (c *CPUCollector) Name() string {
+	return "cpu"
+}
+
+func (c *CPUCollector) Inherit(old Collector) {
+	if old, ok := old.(*CPUCollector); ok {
+		c.prev = old.prev
+	}
+}
+
+func (c *CPUCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	perCore, err := c.times(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return c.utilization(perCore), nil
+}
+
+func (c *CPUCollector) utilization(perCore []cpu.TimesStat) []general.Metrics {
+	metrics := make([]general.Metrics, 0, len(perCore)+1)
+	var busyAll, totalAll float64
+	for i, cur := range perCore {
+		busy, total := cpuBusyTotal(cur)
+		if i < len(c.prev) && c.prev[i].CPU == cur.CPU {
+			prevBusy, prevTotal := cpuBusyTotal(c.prev[i])
+			if total >= prevTotal {
+				busy -= prevBusy
+				total -= prevTotal
+			}
+		}
+		busyAll += busy
+		totalAll += total
+		metrics = append(metrics, gauge(fmt.Sprintf("CPUutilization%d", i+1), cpuPercent(busy, total)))
+	}
+	metrics = append(metrics, gauge("TotalCPUutilization", cpuPercent(busyAll, totalAll)))
+	c.prev = perCore
+	return metrics
+}
+
+// cpuBusyTotal returns busy and total time, guest time is already included in user time
+func cpuBusyTotal(t cpu.TimesStat) (float64, float64) {
+	total := t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+	return total - t.Idle - t.Iowait, total
+}
+
+func cpuPercent(busy float64, total float64) float64 {
+	if total <= 0 || busy <= 0 {
+		return 0
+	}
+	if busy >= total {
+		return 100
+	}
+	return busy / total * 100
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metricsToMap(metrics []general.Metrics) map[string]general.Metrics {
+	m := make(map[string]general.Metrics, len(metrics))
+	for _, v := range metrics {
+		m[v.Key()] = v
+	}
+	return m
+}
+
+func TestCPUCollector_Collect(t *testing.T) {
+	snapshots := [][]cpu.TimesStat{
+		{
+			{CPU: "cpu0", User: 10, System: 10, Idle: 80},
+			{CPU: "cpu1", User: 50, Idle: 50},
+		},
+		{
+			// cpu0 was busy half of the interval, cpu1 was completely busy
+			{CPU: "cpu0", User: 20, System: 10, Idle: 85, Iowait: 5},
+			{CPU: "cpu1", User: 70, Nice: 10, Idle: 50},
+		},
+		{
+			// no time has passed since the previous call
+			{CPU: "cpu0", User: 20, System: 10, Idle: 85, Iowait: 5},
+			{CPU: "cpu1", User: 70, Nice: 10, Idle: 50},
+		},
+	}
+	tests := []struct {
+		name string
+		want map[string]float64
+	}{
+		{
+			name: "since_boot",
+			want: map[string]float64{
+				"CPUutilization1":     20,
+				"CPUutilization2":     50,
+				"TotalCPUutilization": 35,
+			},
+		},
+		{
+			name: "since_previous_call",
+			want: map[string]float64{
+				"CPUutilization1":     50,
+				"CPUutilization2":     100,
+				"TotalCPUutilization": 80,
+			},
+		},
+		{
+			name: "nothing_changed",
+			want: map[string]float64{
+				"CPUutilization1":     0,
+				"CPUutilization2":     0,
+				"TotalCPUutilization": 0,
+			},
+		},
+	}
+	call := 0
+	c := &CPUCollector{
+		times: func(ctx context.Context, percpu bool) ([]cpu.TimesStat, error) {
+			assert.True(t, percpu)
+			return snapshots[call], nil
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := c.Collect(context.Background())
+			require.NoError(t, err)
+			call++
+			got := metricsToMap(metrics)
+			assert.Equal(t, len(tt.want), len(got))
+			for id, want := range tt.want {
+				v, ok := got[id]
+				require.True(t, ok, id)
+				assert.Equal(t, GAUGE, v.MType)
+				assert.InDelta(t, want, *v.Value, 0.0001, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DiskCollector reports usage of mounted filesystems as gauges like 'DiskUsed' labeled by 'mount'
+// and I/O of block devices as counters like 'DiskReadBytes' labeled by 'device'.
+// Mounts filter is applied to mountpoints, Devices filter is applied to device names
+// of partitions (e.g. 'sda1' for '/dev/sda1') and names of block devices.
+type DiskCollector struct {
+	Mounts     Filter
+	Devices    Filter
+	counters   *counterTracker
+	partitions func(ctx context.Context, all bool) ([]disk.PartitionStat, error)
+	usage      func(ctx context.Context, path string) (*disk.UsageStat, error)
+	ioCounters func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+}
+
+// DiskMountLabel and DiskDeviceLabel are labels of metrics which tell filesystem and block device
+const (
+	DiskMountLabel  = "mount"
+	DiskDeviceLabel = "device"
+)
+
+func init() {
+	RegisterCollector("disk", func(cfg *ClientConfig) (Collector, error) {
+		return NewDiskCollector(
+			NewFilter(cfg.DiskMountsInclude, cfg.DiskMountsExclude),
+			NewFilter(cfg.DiskDevicesInclude, cfg.DiskDevicesExclude),
+		), nil
+	})
+}
+
+func NewDiskCollector(mounts Filter, devices Filter) *DiskCollector {
+	return &DiskCollector{
+		Mounts:     mounts,
+		Devices:    devices,
+		counters:   newCounterTracker(),
+		partitions: disk.PartitionsWithContext,
+		usage:      disk.UsageWithContext,
+		ioCounters: disk.IOCountersWithContext,
+	}
+}
+
+func (c *DiskCollector) Name() string {
+	return "disk"
+}
+
+func (c *DiskCollector) Inherit(old Collector) {
+	if old, ok := old.(*DiskCollector); ok {
+		c.counters = old.counters
+	}
+}
+
+// Collect returns metrics of all filesystems and devices which could be read
+// together with errors of the ones which could not
+func (c *DiskCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	metrics := make([]general.Metrics, 0)
+	var rErr error
+	partitions, err := c.partitions(ctx, false)
+	if err != nil {
+		rErr = errors.Join(rErr, err)
+	}
+	seen := make(map[string]bool)
+	for _, p := range partitions {
+		if seen[p.Mountpoint] || !c.Mounts.Match(p.Mountpoint) || !c.Devices.Match(filepath.Base(p.Device)) {
+			continue
+		}
+		seen[p.Mountpoint] = true
+		usage, err := c.usage(ctx, p.Mountpoint)
+		if err != nil {
+			rErr = errors.Join(rErr, fmt.Errorf("usage of '%s': %w", p.Mountpoint, err))
+			continue
+		}
+		labels := map[string]string{DiskMountLabel: p.Mountpoint}
+		metrics = append(
+			metrics,
+			labeled(gauge("DiskTotal", float64(usage.Total)), labels),
+			labeled(gauge("DiskUsed", float64(usage.Used)), labels),
+			labeled(gauge("DiskFree", float64(usage.Free)), labels),
+			labeled(gauge("DiskInodesTotal", float64(usage.InodesTotal)), labels),
+			labeled(gauge("DiskInodesUsed", float64(usage.InodesUsed)), labels),
+			labeled(gauge("DiskInodesFree", float64(usage.InodesFree)), labels),
+		)
+	}
+	devices, err := c.ioCounters(ctx)
+	if err != nil {
+		rErr = errors.Join(rErr, err)
+	}
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		if c.Devices.Match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		io := devices[name]
+		labels := map[string]string{DiskDeviceLabel: name}
+		values := []struct {
+			id    string
+			value uint64
+		}{
+			{"DiskReadBytes", io.ReadBytes},
+			{"DiskWriteBytes", io.WriteBytes},
+			{"DiskReadCount", io.ReadCount},
+			{"DiskWriteCount", io.WriteCount},
+		}
+		for _, v := range values {
+			if delta, ok := c.counters.Delta(v.id+":"+name, v.value); ok {
+				metrics = append(metrics, labeled(counter(v.id, delta), labels))
+			}
+		}
+	}
+	return metrics, rErr
+}
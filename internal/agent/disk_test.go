@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCollector_Collect(t *testing.T) {
+	io := map[string]disk.IOCountersStat{
+		"sda":   {Name: "sda", ReadBytes: 1000, WriteBytes: 2000, ReadCount: 10, WriteCount: 20},
+		"loop0": {Name: "loop0", ReadBytes: 5, WriteBytes: 5, ReadCount: 1, WriteCount: 1},
+	}
+	c := NewDiskCollector(NewFilter("", "/snap/*"), NewFilter("", "loop*"))
+	c.partitions = func(ctx context.Context, all bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/"},
+			{Device: "/dev/sda1", Mountpoint: "/"},
+			{Device: "/dev/sda2", Mountpoint: "/home"},
+			{Device: "/dev/sda3", Mountpoint: "/broken"},
+			{Device: "/dev/loop0", Mountpoint: "/mnt/image"},
+			{Device: "/dev/sda4", Mountpoint: "/snap/core/1"},
+		}, nil
+	}
+	c.usage = func(ctx context.Context, path string) (*disk.UsageStat, error) {
+		switch path {
+		case "/":
+			return &disk.UsageStat{Total: 100, Used: 60, Free: 40, InodesTotal: 10, InodesUsed: 3, InodesFree: 7}, nil
+		case "/home":
+			return &disk.UsageStat{Total: 200, Used: 50, Free: 150, InodesTotal: 20, InodesUsed: 5, InodesFree: 15}, nil
+		}
+		return nil, errors.New("permission denied")
+	}
+	c.ioCounters = func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+		return io, nil
+	}
+
+	metrics, err := c.Collect(context.Background())
+	assert.ErrorContains(t, err, "usage of '/broken'")
+	got := metricsToMap(metrics)
+	assert.Equal(t, 12, len(got))
+	assert.Equal(t, 60.0, *got["DiskUsed{mount=/}"].Value)
+	assert.Equal(t, 40.0, *got["DiskFree{mount=/}"].Value)
+	assert.Equal(t, 100.0, *got["DiskTotal{mount=/}"].Value)
+	assert.Equal(t, 3.0, *got["DiskInodesUsed{mount=/}"].Value)
+	assert.Equal(t, 15.0, *got["DiskInodesFree{mount=/home}"].Value)
+	assert.Equal(t, 20.0, *got["DiskInodesTotal{mount=/home}"].Value)
+	assert.NotContains(t, got, "DiskUsed{mount=/mnt/image}")
+	assert.NotContains(t, got, "DiskUsed{mount=/snap/core/1}")
+	// counters are reported as deltas, so there is nothing to report after the first call
+	assert.NotContains(t, got, "DiskReadBytes{device=sda}")
+
+	io["sda"] = disk.IOCountersStat{Name: "sda", ReadBytes: 1500, WriteBytes: 2000, ReadCount: 15, WriteCount: 21}
+	io["loop0"] = disk.IOCountersStat{Name: "loop0", ReadBytes: 50, WriteBytes: 50, ReadCount: 10, WriteCount: 10}
+	metrics, err = c.Collect(context.Background())
+	assert.Error(t, err)
+	got = metricsToMap(metrics)
+	require.Contains(t, got, "DiskReadBytes{device=sda}")
+	assert.Equal(t, COUNTER, got["DiskReadBytes{device=sda}"].MType)
+	assert.Equal(t, int64(500), *got["DiskReadBytes{device=sda}"].Delta)
+	assert.Equal(t, int64(0), *got["DiskWriteBytes{device=sda}"].Delta)
+	assert.Equal(t, int64(5), *got["DiskReadCount{device=sda}"].Delta)
+	assert.Equal(t, int64(1), *got["DiskWriteCount{device=sda}"].Delta)
+	assert.NotContains(t, got, "DiskReadBytes{device=loop0}")
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// ExecCommand is an external program polled by ExecCollector, Name is used in names of its error counters
+type ExecCommand struct {
+	Name string
+	Path string
+	Args []string
+}
+
+// ExecCollector runs commands and parses their stdout as metrics. Output is either
+// JSON array of metrics in the same format as the server accepts on /updates/
+// or lines '<name> <gauge|counter> <value>', empty lines and lines starting with '#' are skipped.
+// Commands which exit with non-zero code are counted by 'ExecErrors' counter labeled by
+// 'command' name, but their output is still used, commands which run longer than Timeout
+// are killed and counted by 'ExecTimeouts' counter. Commands killed because collecting is
+// stopped, e.g. on reload or shutdown, are not counted.
+type ExecCollector struct {
+	Commands []ExecCommand
+	Timeout  time.Duration
+}
+
+// ExecCommandLabel is a label of metrics which tells name of command
+const ExecCommandLabel = "command"
+
+func init() {
+	RegisterCollector("exec", func(cfg *ClientConfig) (Collector, error) {
+		commands := ParseExecCommands(cfg.ExecCommands)
+		if len(commands) == 0 {
+			return nil, errors.New("there are no commands to run")
+		}
+		if cfg.ExecTimeout <= 0 {
+			return nil, fmt.Errorf("timeout should be positive: %d", cfg.ExecTimeout)
+		}
+		return &ExecCollector{Commands: commands, Timeout: time.Duration(cfg.ExecTimeout) * time.Second}, nil
+	})
+}
+
+// ParseExecCommands parses commands separated by ';', every command is a path to the program
+// and its arguments separated by spaces, the program is run without shell
+func ParseExecCommands(s string) []ExecCommand {
+	commands := make([]ExecCommand, 0)
+	for _, line := range strings.Split(s, ";") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		commands = append(commands, ExecCommand{
+			Name: filepath.Base(fields[0]),
+			Path: fields[0],
+			Args: fields[1:],
+		})
+	}
+	return commands
+}
+
+func (c *ExecCollector) Name() string {
+	return "exec"
+}
+
+// Collect runs all commands at the same time and returns metrics of every of them
+func (c *ExecCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	results := make([][]general.Metrics, len(c.Commands))
+	errs := make([]error, len(c.Commands))
+	var wg sync.WaitGroup
+	for i, command := range c.Commands {
+		wg.Add(1)
+		go func(i int, command ExecCommand) {
+			defer wg.Done()
+			results[i], errs[i] = c.run(ctx, command)
+		}(i, command)
+	}
+	wg.Wait()
+	metrics := make([]general.Metrics, 0)
+	for _, result := range results {
+		metrics = append(metrics, result...)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func (c *ExecCollector) run(ctx context.Context, command ExecCommand) ([]general.Metrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command.Path, command.Args...)
+	// children of the command can keep stdout open after it has been killed
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	labels := map[string]string{ExecCommandLabel: command.Name}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return []general.Metrics{labeled(counter("ExecTimeouts", 1), labels)},
+			fmt.Errorf("command '%s' has not finished in %v", command.Name, c.Timeout)
+	case context.Canceled:
+		return nil, fmt.Errorf("command '%s' has been stopped: %w", command.Name, ctx.Err())
+	}
+	metrics, parseErr := ParseExecOutput(stdout.Bytes())
+	if parseErr != nil {
+		parseErr = fmt.Errorf("output of command '%s' is wrong: %w", command.Name, parseErr)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			// command has not been started, so there is no output
+			return []general.Metrics{labeled(counter("ExecErrors", 1), labels)},
+				fmt.Errorf("command '%s' cannot be run: %w", command.Name, err)
+		}
+		metrics = append(metrics, labeled(counter("ExecErrors", 1), labels))
+		err = fmt.Errorf(
+			"command '%s' has exited with code %d: %s",
+			command.Name, exitErr.ExitCode(), strings.TrimSpace(stderr.String()),
+		)
+	}
+	return metrics, errors.Join(err, parseErr)
+}
+
+// ParseExecOutput parses output of command, correct metrics are returned even if there are wrong lines
+func ParseExecOutput(output []byte) ([]general.Metrics, error) {
+	output = bytes.TrimSpace(output)
+	metrics := make([]general.Metrics, 0)
+	if bytes.HasPrefix(output, []byte("[")) {
+		var parsed []general.Metrics
+		err := json.Unmarshal(output, &parsed)
+		if err != nil {
+			return nil, err
+		}
+		var rErr error
+		for _, metric := range parsed {
+			err = validatePushMetric(metric)
+			if err != nil {
+				rErr = errors.Join(rErr, err)
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+		return metrics, rErr
+	}
+	var rErr error
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			rErr = errors.Join(rErr, fmt.Errorf("line should be in format '<name> <type> <value>': '%s'", line))
+			continue
+		}
+		metric, err := parseURLMetric(fields[1], fields[0], fields[2])
+		if err != nil {
+			rErr = errors.Join(rErr, err)
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, errors.Join(rErr, scanner.Err())
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{
+			name:   "lines",
+			output: "# queue metrics\nQueueLen gauge 12.5\n\nJobsDone counter 3\n",
+			want:   map[string]float64{"QueueLen": 12.5, "JobsDone": 3},
+		},
+		{
+			name:   "json",
+			output: `[{"id":"QueueLen","type":"gauge","value":7},{"id":"JobsDone","type":"counter","delta":2}]`,
+			want:   map[string]float64{"QueueLen": 7, "JobsDone": 2},
+		},
+		{
+			name:    "wrong lines are skipped",
+			output:  "QueueLen gauge 1\nJobsDone counter 1.5\nBroken\nX histogram 1\n",
+			want:    map[string]float64{"QueueLen": 1},
+			wantErr: true,
+		},
+		{
+			name:    "wrong metrics of json are skipped",
+			output:  `[{"id":"QueueLen","type":"gauge","value":7},{"id":"JobsDone","type":"counter"}]`,
+			want:    map[string]float64{"QueueLen": 7},
+			wantErr: true,
+		},
+		{
+			name:    "broken json",
+			output:  `[{"id":"QueueLen"`,
+			want:    map[string]float64{},
+			wantErr: true,
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := ParseExecOutput([]byte(tt.output))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			got := make(map[string]float64)
+			for _, metric := range metrics {
+				if metric.Delta != nil {
+					got[metric.ID] = float64(*metric.Delta)
+				} else {
+					got[metric.ID] = *metric.Value
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseExecCommands(t *testing.T) {
+	commands := ParseExecCommands(" /opt/checks/queue.sh --verbose ; ;check_disk")
+	assert.Equal(t, []ExecCommand{
+		{Name: "queue.sh", Path: "/opt/checks/queue.sh", Args: []string{"--verbose"}},
+		{Name: "check_disk", Path: "check_disk", Args: []string{}},
+	}, commands)
+}
+
+func TestExecCollector_Collect(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name string, body string) ExecCommand {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+		return ExecCommand{Name: name, Path: path}
+	}
+	c := ExecCollector{
+		Commands: []ExecCommand{
+			script("ok.sh", "echo 'QueueLen gauge 5'"),
+			script("json.sh", `echo '[{"id":"JobsDone","type":"counter","delta":4}]'`),
+			script("fails.sh", "echo 'Partial gauge 1'; echo 'cannot connect' >&2; exit 2"),
+			script("hangs.sh", "echo 'Late gauge 1'; exec sleep 10"),
+			{Name: "absent", Path: filepath.Join(dir, "absent")},
+		},
+		Timeout: 500 * time.Millisecond,
+	}
+	start := time.Now()
+	metrics, err := c.Collect(context.Background())
+	assert.Less(t, time.Since(start), 3*time.Second)
+	assert.ErrorContains(t, err, "command 'fails.sh' has exited with code 2: cannot connect")
+	assert.ErrorContains(t, err, "command 'hangs.sh' has not finished")
+	assert.ErrorContains(t, err, "command 'absent' cannot be run")
+	got := metricsToMap(metrics)
+	assert.Equal(t, 6, len(got))
+	assert.Equal(t, 5.0, *got["QueueLen"].Value)
+	assert.Equal(t, int64(4), *got["JobsDone"].Delta)
+	assert.Equal(t, 1.0, *got["Partial"].Value)
+	assert.Equal(t, int64(1), *got["ExecErrors{command=fails.sh}"].Delta)
+	assert.Equal(t, int64(1), *got["ExecTimeouts{command=hangs.sh}"].Delta)
+	assert.Equal(t, int64(1), *got["ExecErrors{command=absent}"].Delta)
+	assert.NotContains(t, got, "Late")
+}
+
+func TestExecCollector_Canceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hangs.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755))
+	c := ExecCollector{Commands: []ExecCommand{{Name: "hangs.sh", Path: path}}, Timeout: 10 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	metrics, err := c.Collect(ctx)
+	assert.Less(t, time.Since(start), 3*time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+	// command killed on reload or shutdown is neither an error nor a timeout
+	assert.Empty(t, metrics)
+}
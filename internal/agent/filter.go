@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Filter selects names by glob patterns where '*' matches any sequence of characters
+// (including '/') and '?' matches any single character. A name is selected when
+// Include is empty or any of its patterns matches, and none of Exclude patterns matches.
+type Filter struct {
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+}
+
+// NewFilter builds Filter from comma separated lists of patterns
+func NewFilter(include string, exclude string) Filter {
+	return Filter{Include: compilePatterns(include), Exclude: compilePatterns(exclude)}
+}
+
+func compilePatterns(patterns string) []*regexp.Regexp {
+	result := make([]*regexp.Regexp, 0)
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString("^")
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		b.WriteString("$")
+		result = append(result, regexp.MustCompile(b.String()))
+	}
+	return result
+}
+
+func (f Filter) Match(name string) bool {
+	for _, re := range f.Exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, re := range f.Include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
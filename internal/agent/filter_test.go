@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		values  map[string]bool
+	}{
+		{
+			name: "empty_matches_everything",
+			values: map[string]bool{
+				"/":     true,
+				"sda":   true,
+				"":      true,
+				"eth0*": true,
+			},
+		},
+		{
+			name:    "exclude",
+			exclude: "loop*, ram?,/run*",
+			values: map[string]bool{
+				"loop0":          false,
+				"ram1":           false,
+				"ram10":          true,
+				"/run/user/1000": false,
+				"/home":          true,
+				"sda":            true,
+			},
+		},
+		{
+			name:    "include_and_exclude",
+			include: "eth*,wlan*",
+			exclude: "eth1",
+			values: map[string]bool{
+				"eth0":  true,
+				"eth1":  false,
+				"wlan0": true,
+				"lo":    false,
+			},
+		},
+		{
+			name:    "special_symbols_are_literal",
+			include: "/var/lib/docker(1)",
+			values: map[string]bool{
+				"/var/lib/docker(1)": true,
+				"/var/lib/docker1":   false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFilter(tt.include, tt.exclude)
+			for value, want := range tt.values {
+				assert.Equal(t, want, f.Match(value), value)
+			}
+		})
+	}
+}
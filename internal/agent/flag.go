@@ -1,35 +1,170 @@
 package agent
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/caarlos0/env/v6"
 )
 
 var HPClient *string
+var SendMode *string
+var ProbeInterval *int
 var ReportInterval *int
 var PollInterval *int
 var AgentKey *string
+var CryptoKey *string
+var TLSCA *string
+var TLSCert *string
+var TLSKey *string
+var TLSServerName *string
 var RateLimit *int
+var GzipLevel *int
+var GzipMinSize *int
+var RetryAttempts *int
+var BreakerThreshold *int
+var BreakerCooldown *int
+var ShutdownTimeout *int
+var QueueDir *string
+var QueueMaxSize *int64
+var DiskMountsInclude *string
+var DiskMountsExclude *string
+var DiskDevicesInclude *string
+var DiskDevicesExclude *string
+var NetInclude *string
+var NetExclude *string
+var CgroupRoot *string
+var ProcRoot *string
+var Collectors *string
+var CollectorIntervals *string
+var MetricsInclude *string
+var MetricsExclude *string
+var AggregateGauges *string
+var TelemetryPrefix *string
+var Host *string
+var Tags *string
+var StatsdAddress *string
+var PushAddress *string
+var ExecCommands *string
+var ExecTimeout *int
+var LogtailRules *string
+var ConfigPath *string
+
+// explicitFlags are flags passed in command line, they have the highest priority
+var explicitFlags map[string]bool
 
 type ClientEnvConfig struct {
-	Address        *string `env:"ADDRESS"`
-	ReportInterval *int    `env:"REPORT_INTERVAL"`
-	PollInterval   *int    `env:"POLL_INTERVAL"`
-	KeyForHash     *string `env:"KEY"`
-	RateLimit      *int    `env:"RATE_LIMIT"`
+	Address          *string `env:"ADDRESS"`
+	SendMode         *string `env:"SEND_MODE"`
+	ProbeInterval    *int    `env:"PROBE_INTERVAL"`
+	ReportInterval   *int    `env:"REPORT_INTERVAL"`
+	PollInterval     *int    `env:"POLL_INTERVAL"`
+	KeyForHash       *string `env:"KEY"`
+	CryptoKey        *string `env:"CRYPTO_KEY"`
+	TLSCA            *string `env:"TLS_CA"`
+	TLSCert          *string `env:"TLS_CERT"`
+	TLSKey           *string `env:"TLS_KEY"`
+	TLSServerName    *string `env:"TLS_SERVER_NAME"`
+	RateLimit        *int    `env:"RATE_LIMIT"`
+	GzipLevel        *int    `env:"GZIP_LEVEL"`
+	GzipMinSize      *int    `env:"GZIP_MIN_SIZE"`
+	RetryAttempts    *int    `env:"RETRY_ATTEMPTS"`
+	BreakerThreshold *int    `env:"BREAKER_THRESHOLD"`
+	BreakerCooldown  *int    `env:"BREAKER_COOLDOWN"`
+	ShutdownTimeout  *int    `env:"SHUTDOWN_TIMEOUT"`
+	QueueDir         *string `env:"QUEUE_DIR"`
+	QueueMaxSize     *int64  `env:"QUEUE_MAX_SIZE"`
+	ConfigPath       *string `env:"CONFIG"`
+
+	DiskMountsInclude  *string `env:"DISK_MOUNTS_INCLUDE"`
+	DiskMountsExclude  *string `env:"DISK_MOUNTS_EXCLUDE"`
+	DiskDevicesInclude *string `env:"DISK_DEVICES_INCLUDE"`
+	DiskDevicesExclude *string `env:"DISK_DEVICES_EXCLUDE"`
+	NetInclude         *string `env:"NET_INCLUDE"`
+	NetExclude         *string `env:"NET_EXCLUDE"`
+	CgroupRoot         *string `env:"CGROUP_ROOT"`
+	ProcRoot           *string `env:"PROC_ROOT"`
+
+	Collectors         *string `env:"COLLECTORS"`
+	CollectorIntervals *string `env:"COLLECTOR_INTERVALS"`
+	MetricsInclude     *string `env:"METRICS_INCLUDE"`
+	MetricsExclude     *string `env:"METRICS_EXCLUDE"`
+	AggregateGauges    *string `env:"AGGREGATE_GAUGES"`
+	TelemetryPrefix    *string `env:"TELEMETRY_PREFIX"`
+	Host               *string `env:"AGENT_HOST"`
+	Tags               *string `env:"TAGS"`
+	StatsdAddress      *string `env:"STATSD_ADDRESS"`
+	PushAddress        *string `env:"PUSH_ADDRESS"`
+	ExecCommands       *string `env:"EXEC_COMMANDS"`
+	ExecTimeout        *int    `env:"EXEC_TIMEOUT"`
+	LogtailRules       *string `env:"LOGTAIL_RULES"`
+}
+
+// ClientFileConfig is a content of JSON config file, absent fields keep their defaults
+type ClientFileConfig struct {
+	Address          *string `json:"address"`
+	SendMode         *string `json:"send_mode"`
+	ProbeInterval    *int    `json:"probe_interval"`
+	ReportInterval   *int    `json:"report_interval"`
+	PollInterval     *int    `json:"poll_interval"`
+	KeyForHash       *string `json:"key"`
+	CryptoKey        *string `json:"crypto_key"`
+	TLSCA            *string `json:"tls_ca"`
+	TLSCert          *string `json:"tls_cert"`
+	TLSKey           *string `json:"tls_key"`
+	TLSServerName    *string `json:"tls_server_name"`
+	RateLimit        *int    `json:"rate_limit"`
+	GzipLevel        *int    `json:"gzip_level"`
+	GzipMinSize      *int    `json:"gzip_min_size"`
+	RetryAttempts    *int    `json:"retry_attempts"`
+	BreakerThreshold *int    `json:"breaker_threshold"`
+	BreakerCooldown  *int    `json:"breaker_cooldown"`
+	ShutdownTimeout  *int    `json:"shutdown_timeout"`
+	QueueDir         *string `json:"queue_dir"`
+	QueueMaxSize     *int64  `json:"queue_max_size"`
+
+	DiskMountsInclude  []string `json:"disk_mounts_include"`
+	DiskMountsExclude  []string `json:"disk_mounts_exclude"`
+	DiskDevicesInclude []string `json:"disk_devices_include"`
+	DiskDevicesExclude []string `json:"disk_devices_exclude"`
+	NetInclude         []string `json:"net_include"`
+	NetExclude         []string `json:"net_exclude"`
+	CgroupRoot         *string  `json:"cgroup_root"`
+	ProcRoot           *string  `json:"proc_root"`
+
+	Collectors         []string          `json:"collectors"`
+	CollectorIntervals map[string]int    `json:"collector_intervals"`
+	MetricsInclude     []string          `json:"metrics_include"`
+	MetricsExclude     []string          `json:"metrics_exclude"`
+	AggregateGauges    []string          `json:"aggregate_gauges"`
+	TelemetryPrefix    *string           `json:"telemetry_prefix"`
+	Host               *string           `json:"host"`
+	Tags               map[string]string `json:"tags"`
+	StatsdAddress      *string           `json:"statsd_address"`
+	PushAddress        *string           `json:"push_address"`
+	ExecCommands       []string          `json:"exec_commands"`
+	ExecTimeout        *int              `json:"exec_timeout"`
+	// LogtailRules is kept as JSON, because it is passed to the flag as is
+	LogtailRules json.RawMessage `json:"logtail_rules"`
 }
 
 func ParseArgsClient() {
-	var cfg ClientEnvConfig
-	err := env.Parse(&cfg)
-	if err != nil {
-		log.Fatal(err)
-	}
-	HPClient = flag.String("a", "localhost:8080", "host and port in format <host>:<port>")
+	HPClient = flag.String(
+		"a", "localhost:8080", "comma separated list of servers in format <host>:<port> ordered by priority",
+	)
+	SendMode = flag.String(
+		"mode", "failover", "'failover' sends batches to the first healthy server, 'fanout' sends them to all servers",
+	)
+	ProbeInterval = flag.Int(
+		"probe-interval", 10, "period of time in seconds, throw of it the primary server is checked after failover",
+	)
 
 	ReportInterval = flag.Int(
 		"r", 10, "period of time in seconds, throw of it will be report to the server",
@@ -40,30 +175,412 @@ func ParseArgsClient() {
 	AgentKey = flag.String(
 		"k", "", "Key to hash requsts and check the sign from server",
 	)
+	CryptoKey = flag.String(
+		"crypto-key", "", "Path to PEM file with public key of the server to encrypt requests, empty value disables it",
+	)
+	TLSCA = flag.String(
+		"tls-ca", "", "Path to PEM CA bundle to verify server certificates, servers are requested over HTTPS if any TLS option is set",
+	)
+	TLSCert = flag.String(
+		"tls-cert", "", "Path to PEM client certificate for mutual TLS",
+	)
+	TLSKey = flag.String(
+		"tls-key", "", "Path to PEM private key of the client certificate",
+	)
+	TLSServerName = flag.String(
+		"tls-server-name", "", "Name server certificates are checked against instead of host of address",
+	)
 	RateLimit = flag.Int(
 		"l", 1, "Limit of simulteniously sending of requests to server",
 	)
+	GzipLevel = flag.Int(
+		"gzip-level", gzip.BestSpeed, "Level of gzip compression of requests from 1 to 9, 0 disables compression",
+	)
+	GzipMinSize = flag.Int(
+		"gzip-min-size", 1024, "Min size of request body in bytes to compress it",
+	)
+	RetryAttempts = flag.Int(
+		"retry-attempts", 4, "Max number of attempts to send a batch, delays between them grow exponentially",
+	)
+	BreakerThreshold = flag.Int(
+		"breaker-threshold", 5, "Number of failed requests in a row which stops sending to the server, 0 disables it",
+	)
+	BreakerCooldown = flag.Int(
+		"breaker-cooldown", 30, "Period of time in seconds the server is not requested after breaker is open",
+	)
+	ShutdownTimeout = flag.Int(
+		"shutdown-timeout", 5, "Period of time in seconds to send metrics polled since the last report on stop, 0 disables it",
+	)
+	QueueDir = flag.String(
+		"q", "", "Directory to keep batches which cannot be sent, it is created accessible only by the user, the queue is disabled if it is empty",
+	)
+	QueueMaxSize = flag.Int64(
+		"qs", 10*1024*1024, "Max size of queue directory in bytes, the oldest batches are dropped above it",
+	)
+	ConfigPath = flag.String(
+		"c", "", "Path to JSON config file, its values have lower priority than flags and environment",
+	)
+	Collectors = flag.String(
+		"collectors", "runtime,memory,cpu,disk,network", "Comma separated list of enabled collectors",
+	)
+	CollectorIntervals = flag.String(
+		"collector-intervals", "", "Poll intervals in seconds of collectors in format <name>=<seconds>,..., poll interval is used by default",
+	)
+	MetricsInclude = flag.String(
+		"metrics-include", "", "Comma separated patterns of metric names to report, all by default",
+	)
+	MetricsExclude = flag.String(
+		"metrics-exclude", "", "Comma separated patterns of metric names to skip",
+	)
+	AggregateGauges = flag.String(
+		"aggregate-gauges", "", "Comma separated patterns of gauges whose min, max and mean over report interval are sent as '<name>.min', '<name>.max' and '<name>.mean'",
+	)
+	TelemetryPrefix = flag.String(
+		"telemetry-prefix", "Agent", "Prefix of names of metrics of the agent itself, empty value disables them",
+	)
+	Host = flag.String(
+		"host", "", "Host identity attached to every metric as 'host' label, metrics have no 'host' label if it is empty, "+
+			"labeled metrics are read from the server only with their labels",
+	)
+	Tags = flag.String(
+		"tags", "", "Comma separated static labels attached to every metric in format <name>=<value>,...",
+	)
+	StatsdAddress = flag.String(
+		"statsd", "", "UDP address in format <host>:<port> to receive StatsD metrics, empty value disables it",
+	)
+	PushAddress = flag.String(
+		"push", "", "Local address in format <host>:<port> to accept metrics from local tools over HTTP, empty value disables it",
+	)
+	ExecCommands = flag.String(
+		"exec", "", "Commands separated by ';' which are run by 'exec' collector, their output is parsed as metrics",
+	)
+	ExecTimeout = flag.Int(
+		"exec-timeout", 5, "Timeout in seconds of commands run by 'exec' collector",
+	)
+	LogtailRules = flag.String(
+		"logtail", "", `JSON array of rules of 'logtail' collector like [{"path":"/var/log/app.log","pattern":"ERROR","counter":"AppErrors"}]`,
+	)
+	DiskMountsInclude = flag.String(
+		"disk-mounts-include", "", "Comma separated patterns of mountpoints to report, all by default",
+	)
+	DiskMountsExclude = flag.String(
+		"disk-mounts-exclude", "/snap/*,/var/lib/docker/*", "Comma separated patterns of mountpoints to skip",
+	)
+	DiskDevicesInclude = flag.String(
+		"disk-devices-include", "", "Comma separated patterns of device names to report, all by default",
+	)
+	DiskDevicesExclude = flag.String(
+		"disk-devices-exclude", "loop*,ram*,tmpfs", "Comma separated patterns of device names to skip",
+	)
+	NetInclude = flag.String(
+		"net-include", "", "Comma separated patterns of network interfaces to report, all by default",
+	)
+	NetExclude = flag.String(
+		"net-exclude", "lo", "Comma separated patterns of network interfaces to skip",
+	)
+	CgroupRoot = flag.String(
+		"cgroup-root", "/sys/fs/cgroup", "Directory of cgroup of the agent read by 'cgroup' collector",
+	)
+	ProcRoot = flag.String(
+		"proc-root", "/proc", "Directory of proc filesystem read by 'pressure' collector",
+	)
 	flag.Parse()
+	explicitFlags = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+	err := applyConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	printConfig()
+}
+
+// ClientConfig is a snapshot of options which can be changed by reload of config file.
+// On reload the file is parsed into a new ClientConfig instead of the flags, so goroutines
+// which are already running never see flag values changing under them.
+type ClientConfig struct {
+	ReportInterval     int
+	PollInterval       int
+	RateLimit          int
+	Key                string
+	Collectors         string
+	CollectorIntervals string
+	MetricsInclude     string
+	MetricsExclude     string
+	AggregateGauges    string
+	Host               string
+	Tags               string
+	ExecCommands       string
+	ExecTimeout        int
+	LogtailRules       string
+	DiskMountsInclude  string
+	DiskMountsExclude  string
+	DiskDevicesInclude string
+	DiskDevicesExclude string
+	NetInclude         string
+	NetExclude         string
+	CgroupRoot         string
+	ProcRoot           string
+}
+
+// flagSet binds fields of the config to flags named like the command line ones
+func (c *ClientConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.IntVar(&c.ReportInterval, "r", 0, "")
+	fs.IntVar(&c.PollInterval, "p", 0, "")
+	fs.IntVar(&c.RateLimit, "l", 0, "")
+	fs.StringVar(&c.Key, "k", "", "")
+	fs.StringVar(&c.Collectors, "collectors", "", "")
+	fs.StringVar(&c.CollectorIntervals, "collector-intervals", "", "")
+	fs.StringVar(&c.MetricsInclude, "metrics-include", "", "")
+	fs.StringVar(&c.MetricsExclude, "metrics-exclude", "", "")
+	fs.StringVar(&c.AggregateGauges, "aggregate-gauges", "", "")
+	fs.StringVar(&c.Host, "host", "", "")
+	fs.StringVar(&c.Tags, "tags", "", "")
+	fs.StringVar(&c.ExecCommands, "exec", "", "")
+	fs.IntVar(&c.ExecTimeout, "exec-timeout", 0, "")
+	fs.StringVar(&c.LogtailRules, "logtail", "", "")
+	fs.StringVar(&c.DiskMountsInclude, "disk-mounts-include", "", "")
+	fs.StringVar(&c.DiskMountsExclude, "disk-mounts-exclude", "", "")
+	fs.StringVar(&c.DiskDevicesInclude, "disk-devices-include", "", "")
+	fs.StringVar(&c.DiskDevicesExclude, "disk-devices-exclude", "", "")
+	fs.StringVar(&c.NetInclude, "net-include", "", "")
+	fs.StringVar(&c.NetExclude, "net-exclude", "", "")
+	fs.StringVar(&c.CgroupRoot, "cgroup-root", "", "")
+	fs.StringVar(&c.ProcRoot, "proc-root", "", "")
+	return fs
+}
+
+// newClientConfig parses values of options returned by value for names of flags
+func newClientConfig(value func(name string) string) (*ClientConfig, error) {
+	var cfg ClientConfig
+	var err error
+	cfg.flagSet().VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if sErr := f.Value.Set(value(f.Name)); sErr != nil {
+			err = fmt.Errorf("wrong value of option '%s': %w", f.Name, sErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// CurrentClientConfig returns reloadable options parsed by ParseArgsClient
+func CurrentClientConfig() (*ClientConfig, error) {
+	return newClientConfig(func(name string) string {
+		return flag.Lookup(name).Value.String()
+	})
+}
+
+// ReloadArgsClient reads config file again into a new ClientConfig, the flags are not changed.
+// Values passed by flags and environment are kept, because they have higher priority.
+// An error is returned if the file cannot be read.
+func ReloadArgsClient() (*ClientConfig, error) {
+	values, err := resolveOptions()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := newClientConfig(func(name string) string {
+		if v, ok := values[name]; ok {
+			return v
+		}
+		// flags passed in command line are never changed after start
+		return flag.Lookup(name).Value.String()
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.print()
+	return cfg, nil
+}
+
+func printConfig() {
+	fmt.Println("Config file:", *ConfigPath)
+	fmt.Printf("Servers are '%s', mode is '%s'\n", *HPClient, *SendMode)
+	fmt.Printf("Queue dir is '%s', max size is %d bytes\n", *QueueDir, *QueueMaxSize)
+	cfg, err := CurrentClientConfig()
+	if err == nil {
+		cfg.print()
+	}
+}
+
+func (c *ClientConfig) print() {
+	fmt.Printf("Poll interval size is %d seconds\n", c.PollInterval)
+	fmt.Printf("Report interval size is %d seconds\n", c.ReportInterval)
+	fmt.Printf("Rate limit is %d\n", c.RateLimit)
+	fmt.Printf("Collectors are '%s', intervals are '%s'\n", c.Collectors, c.CollectorIntervals)
+	fmt.Printf("Metrics include '%s', exclude '%s'\n", c.MetricsInclude, c.MetricsExclude)
+	fmt.Printf("Host is '%s', tags are '%s'\n", c.Host, c.Tags)
+}
+
+// applyConfig sets every option not passed in command line from environment,
+// then from config file, otherwise the default value of the flag is used.
+// It is called only on start, before the flags are read by other goroutines.
+func applyConfig() error {
+	values, err := resolveOptions()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		err = flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("wrong value of option '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveOptions returns values of options not passed in command line by names of their flags
+// taken from environment, then from config file, otherwise the default value of the flag
+func resolveOptions() (map[string]string, error) {
+	var cfg ClientEnvConfig
+	err := env.Parse(&cfg)
+	if err != nil {
+		return nil, err
+	}
 	if cfg.Address != nil {
 		sep := ":"
-		if !strings.Contains(*cfg.Address, sep) {
-			panic(fmt.Errorf("ADDRESS should contain %s symbol to separate host and port", sep))
+		for _, address := range SplitList(*cfg.Address) {
+			if !strings.Contains(address, sep) {
+				return nil, fmt.Errorf("ADDRESS should contain %s symbol to separate host and port", sep)
+			}
+		}
+	}
+	path := *ConfigPath
+	if !explicitFlags["c"] {
+		path = flag.Lookup("c").DefValue
+		if cfg.ConfigPath != nil {
+			path = *cfg.ConfigPath
+		}
+	}
+	var file ClientFileConfig
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file cannot be read: %w", err)
+		}
+		decoder := json.NewDecoder(strings.NewReader(string(b)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+		if err != nil {
+			return nil, fmt.Errorf("config file '%s' cannot be parsed: %w", path, err)
 		}
-		HPClient = cfg.Address
 	}
-	if cfg.ReportInterval != nil {
-		ReportInterval = cfg.ReportInterval
+	var execCommands *string
+	if file.ExecCommands != nil {
+		commands := strings.Join(file.ExecCommands, ";")
+		execCommands = &commands
 	}
-	if cfg.PollInterval != nil {
-		PollInterval = cfg.PollInterval
+	options := []struct {
+		name string
+		env  interface{}
+		file interface{}
+	}{
+		{"c", cfg.ConfigPath, nil},
+		{"a", cfg.Address, file.Address},
+		{"mode", cfg.SendMode, file.SendMode},
+		{"probe-interval", cfg.ProbeInterval, file.ProbeInterval},
+		{"r", cfg.ReportInterval, file.ReportInterval},
+		{"p", cfg.PollInterval, file.PollInterval},
+		{"k", cfg.KeyForHash, file.KeyForHash},
+		{"crypto-key", cfg.CryptoKey, file.CryptoKey},
+		{"tls-ca", cfg.TLSCA, file.TLSCA},
+		{"tls-cert", cfg.TLSCert, file.TLSCert},
+		{"tls-key", cfg.TLSKey, file.TLSKey},
+		{"tls-server-name", cfg.TLSServerName, file.TLSServerName},
+		{"l", cfg.RateLimit, file.RateLimit},
+		{"gzip-level", cfg.GzipLevel, file.GzipLevel},
+		{"gzip-min-size", cfg.GzipMinSize, file.GzipMinSize},
+		{"retry-attempts", cfg.RetryAttempts, file.RetryAttempts},
+		{"breaker-threshold", cfg.BreakerThreshold, file.BreakerThreshold},
+		{"breaker-cooldown", cfg.BreakerCooldown, file.BreakerCooldown},
+		{"shutdown-timeout", cfg.ShutdownTimeout, file.ShutdownTimeout},
+		{"q", cfg.QueueDir, file.QueueDir},
+		{"qs", cfg.QueueMaxSize, file.QueueMaxSize},
+		{"collectors", cfg.Collectors, file.Collectors},
+		{"collector-intervals", cfg.CollectorIntervals, file.CollectorIntervals},
+		{"metrics-include", cfg.MetricsInclude, file.MetricsInclude},
+		{"metrics-exclude", cfg.MetricsExclude, file.MetricsExclude},
+		{"aggregate-gauges", cfg.AggregateGauges, file.AggregateGauges},
+		{"telemetry-prefix", cfg.TelemetryPrefix, file.TelemetryPrefix},
+		{"host", cfg.Host, file.Host},
+		{"tags", cfg.Tags, file.Tags},
+		{"statsd", cfg.StatsdAddress, file.StatsdAddress},
+		{"push", cfg.PushAddress, file.PushAddress},
+		{"exec", cfg.ExecCommands, execCommands},
+		{"exec-timeout", cfg.ExecTimeout, file.ExecTimeout},
+		{"logtail", cfg.LogtailRules, file.LogtailRules},
+		{"disk-mounts-include", cfg.DiskMountsInclude, file.DiskMountsInclude},
+		{"disk-mounts-exclude", cfg.DiskMountsExclude, file.DiskMountsExclude},
+		{"disk-devices-include", cfg.DiskDevicesInclude, file.DiskDevicesInclude},
+		{"disk-devices-exclude", cfg.DiskDevicesExclude, file.DiskDevicesExclude},
+		{"net-include", cfg.NetInclude, file.NetInclude},
+		{"net-exclude", cfg.NetExclude, file.NetExclude},
+		{"cgroup-root", cfg.CgroupRoot, file.CgroupRoot},
+		{"proc-root", cfg.ProcRoot, file.ProcRoot},
 	}
-	if cfg.KeyForHash != nil {
-		AgentKey = cfg.KeyForHash
+	values := make(map[string]string, len(options))
+	for _, o := range options {
+		if explicitFlags[o.name] {
+			continue
+		}
+		value := flag.Lookup(o.name).DefValue
+		if v, ok := optionValue(o.env); ok {
+			value = v
+		} else if v, ok := optionValue(o.file); ok {
+			value = v
+		}
+		values[o.name] = value
 	}
-	if cfg.RateLimit != nil {
-		RateLimit = cfg.RateLimit
+	return values, nil
+}
+
+// optionValue returns value of environment or config file option in format of flag
+func optionValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case *string:
+		if v != nil {
+			return *v, true
+		}
+	case *int:
+		if v != nil {
+			return strconv.Itoa(*v), true
+		}
+	case *int64:
+		if v != nil {
+			return strconv.FormatInt(*v, 10), true
+		}
+	case json.RawMessage:
+		if v != nil {
+			return string(v), true
+		}
+	case []string:
+		if v != nil {
+			return strings.Join(v, ","), true
+		}
+	case map[string]int:
+		if v != nil {
+			items := make([]string, 0, len(v))
+			for name, seconds := range v {
+				items = append(items, fmt.Sprintf("%s=%d", name, seconds))
+			}
+			sort.Strings(items)
+			return strings.Join(items, ","), true
+		}
+	case map[string]string:
+		if v != nil {
+			items := make([]string, 0, len(v))
+			for name, value := range v {
+				items = append(items, fmt.Sprintf("%s=%s", name, value))
+			}
+			sort.Strings(items)
+			return strings.Join(items, ","), true
+		}
 	}
-	fmt.Printf("Poll interval size is %d seconds\n", *PollInterval)
-	fmt.Printf("Report interval size is %d seconds\n", *ReportInterval)
-	fmt.Printf("Rate limit is %d\n", *RateLimit)
+	return "", false
 }
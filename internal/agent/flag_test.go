@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyConfig(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	saved := explicitFlags
+	t.Cleanup(func() {
+		explicitFlags = saved
+		require.NoError(t, applyConfig())
+	})
+	path := filepath.Join(t.TempDir(), "agent.json")
+	config := `{
+		"address": "file:8080",
+		"report_interval": 5,
+		"poll_interval": 3,
+		"rate_limit": 4,
+		"collectors": ["runtime", "cpu"],
+		"collector_intervals": {"runtime": 1, "cpu": 7},
+		"metrics_exclude": ["Random*", "Num*"],
+		"host": "web1",
+		"tags": {"dc": "eu", "env": "prod"}
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	t.Setenv("CONFIG", path)
+	t.Setenv("POLL_INTERVAL", "9")
+	explicitFlags = map[string]bool{"l": true}
+	require.NoError(t, flag.Set("l", "2"))
+
+	require.NoError(t, applyConfig())
+	assert.Equal(t, path, *ConfigPath)
+	assert.Equal(t, "file:8080", *HPClient)
+	assert.Equal(t, 5, *ReportInterval)
+	// environment overrides file
+	assert.Equal(t, 9, *PollInterval)
+	// flag overrides file
+	assert.Equal(t, 2, *RateLimit)
+	assert.Equal(t, "runtime,cpu", *Collectors)
+	assert.Equal(t, "cpu=7,runtime=1", *CollectorIntervals)
+	assert.Equal(t, "Random*,Num*", *MetricsExclude)
+	assert.Equal(t, "web1", *Host)
+	assert.Equal(t, "dc=eu,env=prod", *Tags)
+	// not set anywhere
+	assert.Equal(t, "lo", *NetExclude)
+
+	cfg, err := CurrentClientConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 5, cfg.ReportInterval)
+	assert.Equal(t, 9, cfg.PollInterval)
+	assert.Equal(t, "lo", cfg.NetExclude)
+
+	// option removed from file gets its default value on reload
+	config = `{"report_interval": 8, "address": "other:8080"}`
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	cfg, err = ReloadArgsClient()
+	require.NoError(t, err)
+	assert.Equal(t, 8, cfg.ReportInterval)
+	assert.Equal(t, 9, cfg.PollInterval)
+	assert.Equal(t, "runtime,memory,cpu,disk,network", cfg.Collectors)
+	assert.Equal(t, 2, cfg.RateLimit)
+	assert.Equal(t, "", cfg.Host)
+	assert.Equal(t, "", cfg.Tags)
+	// flags read by running goroutines are not changed by reload
+	assert.Equal(t, 5, *ReportInterval)
+	assert.Equal(t, "file:8080", *HPClient)
+	assert.Equal(t, "runtime,cpu", *Collectors)
+
+	// broken file is not applied
+	for _, config := range []string{`{"report_interval": "8"`, `{"unknown": 1}`, `{"rate_limit": "x"}`} {
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+		_, err = ReloadArgsClient()
+		assert.Error(t, err)
+	}
+	t.Setenv("CONFIG", filepath.Join(t.TempDir(), "absent.json"))
+	_, err = ReloadArgsClient()
+	assert.Error(t, err)
+}
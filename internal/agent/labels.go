@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HostLabel is a label of metrics which tells host they are reported from. It is not set
+// by default, so metrics are stored by their plain IDs and can be read without labels.
+const HostLabel = "host"
+
+// ParseTags parses tags in format 'name=value,name=value'
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, v := range SplitList(s) {
+		name, value, ok := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("tag should be in format <name>=<value>: '%s'", v)
+		}
+		if strings.ContainsAny(name, "{}=") {
+			return nil, fmt.Errorf("tag name cannot contain '{', '}' and '=': '%s'", name)
+		}
+		tags[name] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// MetricLabels returns labels attached to every reported metric: tags and
+// host label if host is not empty, host overrides tag with the same name.
+// It is nil if there are no labels, so metrics keep their plain IDs.
+func MetricLabels(host string, tags string) (map[string]string, error) {
+	labels, err := ParseTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		labels[HostLabel] = host
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		tags    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "nothing", want: nil},
+		{name: "host", host: "web1", want: map[string]string{"host": "web1"}},
+		{
+			name: "host_and_tags",
+			host: "web1",
+			tags: "dc=eu, env = prod,,",
+			want: map[string]string{"host": "web1", "dc": "eu", "env": "prod"},
+		},
+		{name: "host_overrides_tag", host: "web1", tags: "host=other", want: map[string]string{"host": "web1"}},
+		{name: "tags_without_host", tags: "dc=eu", want: map[string]string{"dc": "eu"}},
+		{name: "empty_value", tags: "dc=", want: map[string]string{"dc": ""}},
+		{name: "no_value", tags: "dc", wantErr: true},
+		{name: "no_name", tags: "=eu", wantErr: true},
+		{name: "wrong_name", tags: "d{c}=eu", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MetricLabels(tt.host, tt.tags)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMetricSender_PrepareBatchLabels(t *testing.T) {
+	buffer := NewMetricBuffer()
+	filter := NewFilter("", "Skipped")
+	r := MetricSender{
+		Buffer:       buffer,
+		MetricFilter: &filter,
+		Labels:       map[string]string{"host": "web1"},
+	}
+	buffer.Add(gauge("Alloc", 1), counter("PollCount", 2), gauge("Skipped", 3))
+	batch := r.PrepareBatch()
+	require.Len(t, batch, 2)
+	for _, metric := range batch {
+		assert.Equal(t, map[string]string{"host": "web1"}, metric.Labels)
+	}
+	assert.Equal(t, "Alloc{host=web1}", batch[0].Key())
+
+	// labels of collector are kept apart in the buffer and added to labels of sender
+	buffer.Add(
+		labeled(gauge("DiskUsed", 1), map[string]string{DiskMountLabel: "/"}),
+		labeled(gauge("DiskUsed", 2), map[string]string{DiskMountLabel: "/home"}),
+	)
+	batch = r.PrepareBatch()
+	require.Len(t, batch, 2)
+	assert.Equal(t, "DiskUsed{host=web1,mount=/}", batch[0].Key())
+	assert.Equal(t, "DiskUsed{host=web1,mount=/home}", batch[1].Key())
+	assert.Equal(t, 2.0, *batch[1].Value)
+
+	// new labels are applied to the next report
+	r.SetLabels(nil)
+	buffer.Add(gauge("Alloc", 1))
+	batch = r.PrepareBatch()
+	require.Len(t, batch, 1)
+	assert.Nil(t, batch[0].Labels)
+	assert.Equal(t, "Alloc", batch[0].Key())
+}
@@ -0,0 +1,267 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// logtailMaxLineSize is a limit of line which has no end yet, longer lines are cut
+const logtailMaxLineSize = 64 * 1024
+
+// LogtailRule describes metrics derived from lines of file matching Pattern.
+// Counter is incremented for every matching line, Gauge gets the last value
+// of capturing group Group (the first one by default) of matching lines.
+type LogtailRule struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Counter string `json:"counter"`
+	Gauge   string `json:"gauge"`
+	Group   int    `json:"group"`
+}
+
+type logtailRule struct {
+	LogtailRule
+	re *regexp.Regexp
+}
+
+// LogtailCollector follows log files like 'tail -F' does. Lines written before the first
+// poll are skipped. When file is rotated the rest of the old one is read and the new one
+// is read from the beginning, when file is truncated it is read from the beginning too.
+type LogtailCollector struct {
+	files []*tailedFile
+}
+
+type tailedFile struct {
+	path    string
+	rules   []logtailRule
+	file    *os.File
+	offset  int64
+	partial []byte
+	started bool
+}
+
+func init() {
+	RegisterCollector("logtail", func(cfg *ClientConfig) (Collector, error) {
+		rules, err := ParseLogtailRules(cfg.LogtailRules)
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) == 0 {
+			return nil, errors.New("there are no rules of files to tail")
+		}
+		return NewLogtailCollector(rules)
+	})
+}
+
+// ParseLogtailRules parses JSON array of rules
+func ParseLogtailRules(s string) ([]LogtailRule, error) {
+	rules := make([]LogtailRule, 0)
+	if strings.TrimSpace(s) == "" {
+		return rules, nil
+	}
+	err := json.Unmarshal([]byte(s), &rules)
+	if err != nil {
+		return nil, fmt.Errorf("rules of files to tail cannot be parsed: %w", err)
+	}
+	return rules, nil
+}
+
+func NewLogtailCollector(rules []LogtailRule) (*LogtailCollector, error) {
+	c := &LogtailCollector{}
+	files := make(map[string]*tailedFile)
+	for _, rule := range rules {
+		if rule.Path == "" {
+			return nil, fmt.Errorf("rule with pattern '%s' has no path", rule.Pattern)
+		}
+		if rule.Counter == "" && rule.Gauge == "" {
+			return nil, fmt.Errorf("rule with pattern '%s' has neither counter nor gauge", rule.Pattern)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("wrong pattern of file '%s': %w", rule.Path, err)
+		}
+		if rule.Gauge != "" {
+			if rule.Group == 0 {
+				rule.Group = 1
+			}
+			if rule.Group < 0 || rule.Group > re.NumSubexp() {
+				return nil, fmt.Errorf("pattern '%s' has no capturing group %d", rule.Pattern, rule.Group)
+			}
+		}
+		f, ok := files[rule.Path]
+		if !ok {
+			f = &tailedFile{path: rule.Path}
+			files[rule.Path] = f
+			c.files = append(c.files, f)
+		}
+		f.rules = append(f.rules, logtailRule{LogtailRule: rule, re: re})
+	}
+	return c, nil
+}
+
+func (c *LogtailCollector) Name() string {
+	return "logtail"
+}
+
+// Inherit takes over files which are still followed, so lines written during reload are not lost,
+// files which are not followed anymore are closed
+func (c *LogtailCollector) Inherit(old Collector) {
+	o, ok := old.(*LogtailCollector)
+	if !ok {
+		return
+	}
+	for _, prev := range o.files {
+		taken := false
+		for _, f := range c.files {
+			if f.path == prev.path {
+				f.file, f.offset, f.partial, f.started = prev.file, prev.offset, prev.partial, prev.started
+				taken = true
+			}
+		}
+		if !taken && prev.file != nil {
+			prev.file.Close()
+		}
+	}
+}
+
+// Close closes followed files, it is called when the collector is not used anymore
+func (c *LogtailCollector) Close() error {
+	var err error
+	for _, f := range c.files {
+		if f.file != nil {
+			err = errors.Join(err, f.file.Close())
+			f.file = nil
+		}
+	}
+	return err
+}
+
+// Collect reads lines appended to files since the previous call. Counters of all rules
+// are reported even if there are no new lines, so it is seen that files are followed.
+func (c *LogtailCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	metrics := make([]general.Metrics, 0)
+	var rErr error
+	for _, f := range c.files {
+		lines, err := f.readLines()
+		if err != nil {
+			rErr = errors.Join(rErr, err)
+		}
+		metrics = append(metrics, f.match(lines)...)
+	}
+	return metrics, rErr
+}
+
+func (f *tailedFile) match(lines []string) []general.Metrics {
+	metrics := make([]general.Metrics, 0)
+	for _, rule := range f.rules {
+		var count int64
+		var value float64
+		hasValue := false
+		for _, line := range lines {
+			groups := rule.re.FindStringSubmatch(line)
+			if groups == nil {
+				continue
+			}
+			count++
+			if rule.Gauge == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(groups[rule.Group], 64)
+			if err == nil {
+				value = v
+				hasValue = true
+			}
+		}
+		if rule.Counter != "" {
+			metrics = append(metrics, counter(rule.Counter, count))
+		}
+		if hasValue {
+			metrics = append(metrics, gauge(rule.Gauge, value))
+		}
+	}
+	return metrics
+}
+
+// readLines returns complete lines written since the previous call
+func (f *tailedFile) readLines() ([]string, error) {
+	lines := make([]string, 0)
+	var rErr error
+	if f.file != nil {
+		// the rest of the file is read even if it has been rotated already
+		err := f.read(&lines)
+		if err != nil {
+			rErr = errors.Join(rErr, err)
+		}
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.started = true
+		return lines, errors.Join(rErr, err)
+	}
+	if f.file != nil {
+		current, err := f.file.Stat()
+		if err == nil && os.SameFile(info, current) {
+			if current.Size() < f.offset {
+				fmt.Printf("File '%s' has been truncated\n", f.path)
+				f.offset = 0
+				f.partial = nil
+				rErr = errors.Join(rErr, f.read(&lines))
+			}
+			return lines, rErr
+		}
+		fmt.Printf("File '%s' has been rotated\n", f.path)
+		f.file.Close()
+		f.file = nil
+		if len(f.partial) > 0 {
+			lines = append(lines, string(f.partial))
+			f.partial = nil
+		}
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		f.started = true
+		return lines, errors.Join(rErr, err)
+	}
+	f.file = file
+	f.offset = 0
+	if !f.started {
+		f.offset = info.Size()
+		f.started = true
+		return lines, rErr
+	}
+	return lines, errors.Join(rErr, f.read(&lines))
+}
+
+func (f *tailedFile) read(lines *[]string) error {
+	_, err := f.file.Seek(f.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f.file)
+	f.offset += int64(len(data))
+	data = append(f.partial, data...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		*lines = append(*lines, strings.TrimRight(string(data[:i]), "\r"))
+		data = data[i+1:]
+	}
+	if len(data) > logtailMaxLineSize {
+		*lines = append(*lines, string(data))
+		data = nil
+	}
+	f.partial = append([]byte(nil), data...)
+	return err
+}
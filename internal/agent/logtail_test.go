@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendToFile(t *testing.T, path string, s string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(s)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestLogtailCollector_Collect(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	appendToFile(t, path, "ERROR written before start\n")
+	rules, err := ParseLogtailRules(`[
+		{"path": "` + path + `", "pattern": "ERROR", "counter": "Errors"},
+		{"path": "` + path + `", "pattern": "rt=([0-9.]+)", "counter": "Requests", "gauge": "RequestTime"}
+	]`)
+	require.NoError(t, err)
+	c, err := NewLogtailCollector(rules)
+	require.NoError(t, err)
+
+	collect := func() map[string]float64 {
+		metrics, err := c.Collect(context.Background())
+		require.NoError(t, err)
+		got := make(map[string]float64)
+		for _, metric := range metrics {
+			if metric.Delta != nil {
+				got[metric.ID] = float64(*metric.Delta)
+			} else {
+				got[metric.ID] = *metric.Value
+			}
+		}
+		return got
+	}
+
+	// lines written before the first poll are skipped
+	assert.Equal(t, map[string]float64{"Errors": 0, "Requests": 0}, collect())
+
+	appendToFile(t, path, "GET / rt=0.5\nERROR something\nGET /a rt=1.5\nGET /b rt=0.2")
+	// the last line is not complete yet
+	assert.Equal(t, map[string]float64{"Errors": 1, "Requests": 2, "RequestTime": 1.5}, collect())
+	appendToFile(t, path, "5\n")
+	assert.Equal(t, map[string]float64{"Errors": 0, "Requests": 1, "RequestTime": 0.25}, collect())
+
+	// rotation: the rest of the old file and the whole new one are read
+	appendToFile(t, path, "ERROR before rotation\n")
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendToFile(t, path+".1", "ERROR after rotation\n")
+	appendToFile(t, path, "ERROR in new file\nGET / rt=3\n")
+	assert.Equal(t, map[string]float64{"Errors": 3, "Requests": 1, "RequestTime": 3}, collect())
+
+	// truncation: the file is read from the beginning
+	require.NoError(t, os.WriteFile(path, []byte("ERROR\n"), 0o644))
+	assert.Equal(t, map[string]float64{"Errors": 1, "Requests": 0}, collect())
+
+	// reload: new collector goes on from the same position
+	appendToFile(t, path, "ERROR during reload\n")
+	next, err := NewLogtailCollector(rules[:1])
+	require.NoError(t, err)
+	next.Inherit(c)
+	c = next
+	assert.Equal(t, map[string]float64{"Errors": 1}, collect())
+
+	// file has been removed
+	require.NoError(t, os.Remove(path))
+	_, err = c.Collect(context.Background())
+	assert.Error(t, err)
+	appendToFile(t, path, "ERROR in recreated file\n")
+	assert.Equal(t, map[string]float64{"Errors": 1}, collect())
+}
+
+func TestNewLogtailCollector(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{name: "no path", rules: `[{"pattern": "ERROR", "counter": "Errors"}]`},
+		{name: "no metrics", rules: `[{"path": "/var/log/app.log", "pattern": "ERROR"}]`},
+		{name: "wrong pattern", rules: `[{"path": "/var/log/app.log", "pattern": "(", "counter": "Errors"}]`},
+		{name: "no group", rules: `[{"path": "/var/log/app.log", "pattern": "rt=", "gauge": "RequestTime"}]`},
+		{name: "wrong group", rules: `[{"path": "/var/log/app.log", "pattern": "rt=(.*)", "gauge": "RequestTime", "group": 2}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseLogtailRules(tt.rules)
+			require.NoError(t, err)
+			_, err = NewLogtailCollector(rules)
+			assert.Error(t, err)
+		})
+	}
+	_, err := ParseLogtailRules(`{"path": "/var/log/app.log"}`)
+	assert.Error(t, err)
+}
+
+func TestLogtailCollector_CloseOnReloadAndStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	appendToFile(t, path, "started\n")
+	newCollector := func() (*LogtailCollector, *os.File) {
+		c, err := NewLogtailCollector([]LogtailRule{{Path: path, Pattern: "ERROR", Counter: "Errors"}})
+		require.NoError(t, err)
+		_, err = c.Collect(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, c.files[0].file)
+		return c, c.files[0].file
+	}
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	removed, removedFile := newCollector()
+	r := MetricSender{
+		URL:                "http://127.0.0.1:1",
+		Client:             resty.New(),
+		ReportIntervalTime: 60,
+		Collectors:         []ScheduledCollector{{Collector: removed, Interval: time.Hour}},
+		Done:               done,
+		WG:                 &wg,
+	}
+	wg.Add(1)
+	r.Run()
+
+	// collector removed on reload closes its files
+	stopped, stoppedFile := newCollector()
+	require.NoError(t, r.Reload([]ScheduledCollector{{Collector: &fakeCollector{name: "a"}, Interval: time.Hour}}, nil))
+	assert.ErrorIs(t, removedFile.Close(), os.ErrClosed)
+	require.NoError(t, r.Reload([]ScheduledCollector{{Collector: stopped, Interval: time.Hour}}, nil))
+	// collector added on reload keeps its files open
+	_, err := stoppedFile.Stat()
+	assert.NoError(t, err)
+
+	// running collectors close their files when the sender is stopped
+	close(done)
+	wg.Wait()
+	assert.ErrorIs(t, stoppedFile.Close(), os.ErrClosed)
+}
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// MemoryCollector reports total and free virtual memory of the host
+type MemoryCollector struct{}
+
+func init() {
+	RegisterCollector("memory", func(cfg *ClientConfig) (Collector, error) {
+		return &MemoryCollector{}, nil
+	})
+}
+
+func (c *MemoryCollector) Name() string {
+	return "memory"
+}
+
+func (c *MemoryCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []general.Metrics{
+		gauge("TotalMemory", float64(v.Total)),
+		gauge("FreeMemory", float64(v.Free)),
+	}, nil
+}
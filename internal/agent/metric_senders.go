@@ -1,178 +1,303 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"net"
 	"net/http"
-	"runtime"
 	"sync"
-	"sync/atomic"
-	"syscall"
 	"time"
 
 	"crypto/hmac"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
 
 	"github.com/akashipov/MetricCollector/internal/general"
 	"github.com/go-resty/resty/v2"
-	"github.com/shirou/gopsutil/v3/mem"
 )
 
-var ListMetrics = []string{
-	"Alloc",
-	"BuckHashSys",
-	"Frees",
-	"GCCPUFraction",
-	"GCSys",
-	"HeapAlloc",
-	"HeapIdle",
-	"HeapInuse",
-	"HeapObjects",
-	"HeapReleased",
-	"HeapSys",
-	"LastGC",
-	"Lookups",
-	"MCacheInuse",
-	"MCacheSys",
-	"MSpanInuse",
-	"MSpanSys",
-	"Mallocs",
-	"NextGC",
-	"NumForcedGC",
-	"NumGC",
-	"OtherSys",
-	"PauseTotalNs",
-	"StackInuse",
-	"StackSys",
-	"Sys",
-	"TotalAlloc",
-	"TotalMemory",
-	"FreeMemory",
-	"CPUutilization1",
-}
-
-type MetricSenderInterface interface {
-	pollInterval(bool)
-	reportInterval()
-}
-
 const COUNTER string = "counter"
 const GAUGE string = "gauge"
 
+func gauge(id string, v float64) general.Metrics {
+	return general.Metrics{ID: id, MType: GAUGE, Value: &v}
+}
+
+func counter(id string, delta int64) general.Metrics {
+	return general.Metrics{ID: id, MType: COUNTER, Delta: &delta}
+}
+
+// labeled attaches labels of collector to metric, e.g. filesystem it is reported for
+func labeled(metric general.Metrics, labels map[string]string) general.Metrics {
+	metric.Labels = labels
+	return metric
+}
+
 type MetricSender struct {
-	URL                string
-	ListMetrics        *[]string
-	Client             *resty.Client
-	ReportIntervalTime *int
-	PollIntervalTime   *int
-	Done               chan bool
-	M                  *sync.Mutex
-	GoPull             chan struct{}
-	WG                 *sync.WaitGroup
+	URL string
+	// Servers are used instead of URL to send batches if it is set
+	Servers *ServerPool
+	Client  *resty.Client
+	// ReportIntervalTime is a period of reports in seconds, RateLimit is a number of requests
+	// in flight and Key signs request bodies with HashSHA256 if it is not empty.
+	// They are changed by SetSendOptions once the sender is running.
+	ReportIntervalTime int
+	RateLimit          int
+	Key                string
+	Queue              *DiskQueue
+	Buffer             *MetricBuffer
+	Collectors         []ScheduledCollector
+	// Retry is a policy of sending, general.DefaultRetryPolicy is used if it is nil
+	Retry *general.RetryPolicy
+	// BreakerThreshold is a number of failures in a row which stops sending to the server
+	// for BreakerCooldown, breakers are disabled if it is not positive
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// GzipLevel is a level of compression of request bodies, they are not compressed if it is 0
+	GzipLevel int
+	// GzipMinSize is a size of body in bytes starting from which it is compressed
+	GzipMinSize int
+	// PublicKey is a key of the server to encrypt request bodies, they are not encrypted if it is nil
+	PublicKey *rsa.PublicKey
+	// Telemetry records metrics of the sender itself, it is disabled if it is nil
+	Telemetry *Telemetry
+	// MetricFilter drops metrics with not matching names from reports, all are sent if it is nil
+	MetricFilter *Filter
+	// Labels are attached to every reported metric, e.g. host identity and static tags
+	Labels map[string]string
+	// FlushTimeout is a deadline of sends in flight and the final report after Done is closed,
+	// metrics polled since the last report are lost on stop if it is not positive
+	FlushTimeout time.Duration
+	Done         chan bool
+	WG           *sync.WaitGroup
+
+	m             sync.Mutex
+	deliverM      sync.Mutex
+	ctx           context.Context
+	sendCtx       context.Context
+	flushErr      error
+	unsent        []general.Metrics
+	collectCancel context.CancelFunc
+	collectWG     sync.WaitGroup
+	reload        chan bool
+	breakers      map[string]*general.CircuitBreaker
+	realIPs       map[string]*realIPEntry
+	// outboundIP finds address of the agent to the server, OutboundIP is used if it is nil
+	outboundIP func(serverURL string) (net.IP, error)
+}
+
+// realIPEntry is outbound address of the agent to the server, if it is not found
+// it is looked for again after the backoff
+type realIPEntry struct {
+	ip      string
+	backoff time.Duration
+	next    time.Time
 }
 
+// realIPMinBackoff and realIPMaxBackoff limit the time between lookups of address which cannot be found
+const (
+	realIPMinBackoff = time.Second
+	realIPMaxBackoff = 5 * time.Minute
+)
+
 func (r *MetricSender) Run() {
-	memInfo := make(map[string]interface{})
-	var countOfUpdate atomic.Int64
-	r.WG.Add(1)
-	go r.TickerWithSignal()
-	r.WG.Add(1)
-	go func() {
-		fmt.Println("Has been started PollInterval")
-		r.PollInterval(&memInfo, &countOfUpdate)
-		r.WG.Done()
-	}()
+	defer r.WG.Done()
+	if r.Buffer == nil {
+		r.Buffer = NewMetricBuffer()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sendCtx, sendCancel := context.WithCancel(context.Background())
+	r.m.Lock()
+	r.reload = make(chan bool, 1)
+	r.ctx = ctx
+	r.sendCtx = sendCtx
+	r.startCollectors()
+	r.m.Unlock()
 	r.WG.Add(1)
 	go func() {
-		fmt.Println("Has been started Additional metrics collecting")
-		r.AddInterval(&memInfo, &countOfUpdate)
-		r.WG.Done()
+		defer r.WG.Done()
+		<-r.Done
+		r.m.Lock()
+		cancel()
+		r.m.Unlock()
+		if r.FlushTimeout <= 0 {
+			sendCancel()
+		} else {
+			// sends in flight and the final report are interrupted at the deadline
+			time.AfterFunc(r.FlushTimeout, sendCancel)
+		}
+		r.collectWG.Wait()
 	}()
+	if r.Servers != nil && len(r.Servers.URLs) > 1 && !r.Servers.FanOut && r.Servers.ProbeInterval > 0 {
+		r.WG.Add(1)
+		go func() {
+			defer r.WG.Done()
+			r.ProbeInterval()
+		}()
+	}
 	r.WG.Add(1)
 	go func() {
+		defer r.WG.Done()
 		fmt.Println("Has been started ReportInterval")
-		r.ReportInterval(&memInfo, &countOfUpdate)
-		r.WG.Done()
+		r.ReportInterval()
+		if r.FlushTimeout > 0 {
+			err := r.Flush()
+			r.m.Lock()
+			r.flushErr = err
+			r.m.Unlock()
+		}
+		sendCancel()
+		r.collectWG.Wait()
+		r.m.Lock()
+		collectors := r.Collectors
+		r.m.Unlock()
+		for _, c := range collectors {
+			closeCollector(c.Collector)
+		}
 	}()
-	r.WG.Done()
 }
 
-func (r *MetricSender) TickerWithSignal() {
-	defer r.WG.Done()
-loop:
-	for {
-		tickerPollInterval := time.NewTicker(time.Duration(*r.PollIntervalTime) * time.Second)
-		defer tickerPollInterval.Stop()
-		select {
-		case <-tickerPollInterval.C:
-			// Is it good practice or not?
-			r.GoPull <- struct{}{}
-			r.GoPull <- struct{}{}
-		case <-r.Done:
-			break loop
+// Flush polls all collectors once more after they are stopped and sends everything
+// collected since the last report, it is called on stop till FlushTimeout is over
+func (r *MetricSender) Flush() error {
+	r.collectWG.Wait()
+	ctx := r.context()
+	r.m.Lock()
+	collectors := r.Collectors
+	r.m.Unlock()
+	for _, c := range collectors {
+		r.Collect(ctx, c.Collector)
+	}
+	metrics := append(r.unsent, r.PrepareBatch()...)
+	r.unsent = nil
+	if len(metrics) == 0 {
+		fmt.Println("There is nothing to flush")
+		return nil
+	}
+	err := r.Deliver(metrics)
+	if err != nil {
+		return fmt.Errorf("final report has failed: %w", err)
+	}
+	fmt.Println("Final report has been sent")
+	return nil
+}
+
+// FlushErr returns error of the final report, it is nil till the sender is stopped
+func (r *MetricSender) FlushErr() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.flushErr
+}
+
+// startCollectors runs polling of r.Collectors, it has to be called under r.m
+func (r *MetricSender) startCollectors() {
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.collectCancel = cancel
+	for _, c := range r.Collectors {
+		r.collectWG.Add(1)
+		go func(c ScheduledCollector) {
+			defer r.collectWG.Done()
+			fmt.Printf("Has been started collector '%s' with interval %v\n", c.Collector.Name(), c.Interval)
+			r.CollectInterval(ctx, c)
+		}(c)
+	}
+}
+
+// Reload replaces collectors and metric filter of the running sender. New collectors inherit
+// state of running ones with the same names, so their counters go on from the previous values.
+// Metrics which are already in the buffer are sent with the next report.
+func (r *MetricSender) Reload(collectors []ScheduledCollector, filter *Filter) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.ctx == nil || r.ctx.Err() != nil {
+		return errors.New("metric sender is not running")
+	}
+	r.collectCancel()
+	r.collectWG.Wait()
+	running := make(map[string]Collector)
+	for _, c := range r.Collectors {
+		running[c.Collector.Name()] = c.Collector
+	}
+	for _, c := range collectors {
+		old, ok := running[c.Collector.Name()]
+		if s, isStateful := c.Collector.(StatefulCollector); ok && isStateful {
+			s.Inherit(old)
+			delete(running, c.Collector.Name())
 		}
 	}
+	// collectors whose state is not taken over by new ones are not used anymore
+	for _, c := range running {
+		closeCollector(c)
+	}
+	r.Collectors = collectors
+	r.MetricFilter = filter
+	r.startCollectors()
+	return nil
+}
+
+// SetSendOptions replaces key, report interval and rate limit of the running sender,
+// ReportInterval applies the new interval and restarts workers if the rate limit is changed
+func (r *MetricSender) SetSendOptions(key string, reportInterval int, rateLimit int) {
+	r.m.Lock()
+	r.Key = key
+	r.ReportIntervalTime = reportInterval
+	r.RateLimit = rateLimit
+	reload := r.reload
+	r.m.Unlock()
+	select {
+	case reload <- true:
+	default:
+	}
 }
 
-func (r *MetricSender) AddInterval(
-	memInfo *map[string]interface{},
-	counter *atomic.Int64,
-) {
+func (r *MetricSender) key() string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.Key
+}
+
+func (r *MetricSender) reportInterval() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.ReportIntervalTime
+}
+
+// CollectInterval polls collector with its interval until ctx is done
+func (r *MetricSender) CollectInterval(ctx context.Context, c ScheduledCollector) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-r.GoPull:
-			v, _ := mem.VirtualMemory()
-			r.M.Lock()
-			(*memInfo)["TotalMemory"] = float64(v.Total)
-			(*memInfo)["FreeMemory"] = float64(v.Free)
-			(*memInfo)["CPUutilization1"] = v.UsedPercent
-			r.M.Unlock()
-			counter.Add(1)
-			fmt.Println("Done AddInterval!")
-		case <-r.Done:
+		case <-ticker.C:
+			r.Collect(ctx, c.Collector)
+		case <-ctx.Done():
 			return
-		default:
-			continue
 		}
 	}
 }
 
-func (r *MetricSender) PollInterval(
-	memInfo *map[string]interface{},
-	counter *atomic.Int64,
-) {
-	for {
-		select {
-		case <-r.GoPull:
-			var memStats runtime.MemStats
-			runtime.ReadMemStats(&memStats)
-			b, err := json.Marshal(memStats)
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
-			r.M.Lock()
-			err = json.Unmarshal(b, memInfo)
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
-			r.M.Unlock()
-			counter.Add(1)
-			fmt.Println("Done PollInterval!")
-		case <-r.Done:
-			return
-		default:
-			continue
+// Collect puts metrics of collector to the buffer. Errors and panics of the collector
+// are only logged, so one broken source does not affect the others.
+func (r *MetricSender) Collect(ctx context.Context, c Collector) {
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("Collector '%s' has panicked: %v\n", c.Name(), p)
 		}
+	}()
+	start := time.Now()
+	metrics, err := c.Collect(ctx)
+	r.Telemetry.Collected(c.Name(), time.Since(start))
+	if err != nil {
+		fmt.Printf("Some of '%s' metrics cannot be collected: %s\n", c.Name(), err.Error())
 	}
+	r.Buffer.Add(metrics...)
+	fmt.Printf("Done collector '%s'!\n", c.Name())
 }
 
 func (r *MetricSender) SendMetric(value interface{}, metricType string, metricName string) error {
-	url := fmt.Sprintf("%s/update/", r.URL)
 	var s string
 	switch metricType {
 	case COUNTER:
@@ -182,117 +307,405 @@ func (r *MetricSender) SendMetric(value interface{}, metricType string, metricNa
 	default:
 		return fmt.Errorf("wrong type of metric: %v", metricType)
 	}
-	req := r.Client.R().SetBody(s).SetHeader("Content-Type", "application/json")
-	if *AgentKey != "" {
-		encoder := hmac.New(sha256.New, []byte(*AgentKey))
-		encoder.Write([]byte(s))
-		v := encoder.Sum(nil)
-		req.SetHeader("HashSHA256", base64.RawURLEncoding.EncodeToString(v[:]))
-	}
-	var err error
-	var resp *resty.Response
-	f := func() error {
-		resp, err = req.Post(
-			url,
-		)
+	return r.post(r.URL, "/update/", []byte(s))
+}
+
+func (r *MetricSender) SendMetrics(metrics []general.Metrics) error {
+	s, err := json.Marshal(metrics)
+	if err != nil {
 		return err
 	}
-	err = general.RetryCode(f, syscall.ECONNREFUSED)
+	if r.Servers == nil {
+		err = r.post(r.URL, "/updates/", s)
+	} else {
+		err = r.Servers.Send(func(url string) error {
+			return r.post(url, "/updates/", s)
+		})
+	}
 	if err != nil {
-		fmt.Printf("Request cannot be precossed, something is wrong: %s\n", err.Error())
+		r.Telemetry.BatchFailed()
 		return err
 	}
-	if resp.StatusCode() != http.StatusOK && resp.StatusCode() != http.StatusCreated {
-		fmt.Printf("Something wrong with resp - '%v', status code - %v\n", resp, resp.StatusCode())
+	r.Telemetry.BatchSent()
+	return nil
+}
+
+// post sends signed body to the server repeating it by retry policy. Body is compressed
+// if it is big enough, then encrypted if PublicKey is set, and HashSHA256 is computed
+// over the bytes which are actually sent, so the server checks the sign before decryption.
+func (r *MetricSender) post(serverURL string, path string, s []byte) error {
+	url := serverURL + path
+	fmt.Println("Sending post request with url: " + url)
+	encoding := ""
+	if r.GzipLevel != 0 && len(s) >= r.GzipMinSize {
+		compressed, err := general.Compress(s, r.GzipLevel)
+		if err != nil {
+			return err
+		}
+		s = compressed
+		encoding = "gzip"
+	}
+	if r.PublicKey != nil {
+		encrypted, err := general.Encrypt(r.PublicKey, s)
+		if err != nil {
+			return fmt.Errorf("body cannot be encrypted: %w", err)
+		}
+		s = encrypted
+	}
+	policy := general.DefaultRetryPolicy()
+	if r.Retry != nil {
+		policy = *r.Retry
+	}
+	policy.Breaker = r.breaker(serverURL)
+	policy.OnRetry = func(attempt int, err error) {
+		r.Telemetry.Retry()
+	}
+	err := policy.Do(r.context(), func(ctx context.Context) error {
+		req := r.Client.R().SetContext(ctx).SetBody(s).SetHeader("Content-Type", "application/json")
+		if encoding != "" {
+			req.SetHeader("Content-Encoding", encoding)
+		}
+		if r.PublicKey != nil {
+			req.SetHeader("Content-Encryption", general.EncryptionScheme)
+		}
+		if ip := r.realIP(serverURL); ip != "" {
+			req.SetHeader("X-Real-IP", ip)
+		}
+		r.sign(req, s)
+		start := time.Now()
+		resp, err := req.Post(url)
+		if err == nil && resp.StatusCode() != http.StatusOK && resp.StatusCode() != http.StatusCreated {
+			err = general.NewHTTPStatusError(resp.StatusCode(), resp.Header(), resp.String())
+		}
+		r.Telemetry.Request(time.Since(start), len(s), err == nil)
 		return err
+	})
+	if err != nil {
+		return fmt.Errorf("request cannot be processed: %w", err)
 	}
-	fmt.Printf("Success: %v\n", resp.StatusCode())
+	fmt.Println("Success")
 	return nil
 }
 
-func (r *MetricSender) SendMetrics(metrics []general.Metrics) error {
-	url := fmt.Sprintf("%s/updates/", r.URL)
-	fmt.Println("Sending post request with url: " + url)
-	var err error
-	s, err := json.Marshal(metrics)
+// breaker returns circuit breaker of the server, it is nil if breakers are disabled
+func (r *MetricSender) breaker(serverURL string) *general.CircuitBreaker {
+	if r.BreakerThreshold <= 0 {
+		return nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.breakers == nil {
+		r.breakers = make(map[string]*general.CircuitBreaker)
+	}
+	b, ok := r.breakers[serverURL]
+	if !ok {
+		b = general.NewCircuitBreaker(r.BreakerThreshold, r.BreakerCooldown)
+		r.breakers[serverURL] = b
+	}
+	return b
+}
+
+// realIP returns outbound address of the agent sent to the server in X-Real-IP,
+// it is found once per server and is empty if the route cannot be found. Failed lookup
+// is repeated after the backoff which is doubled on every failure.
+func (r *MetricSender) realIP(serverURL string) string {
+	r.m.Lock()
+	entry, ok := r.realIPs[serverURL]
+	lookup := r.outboundIP
+	r.m.Unlock()
+	if ok && (entry.ip != "" || time.Now().Before(entry.next)) {
+		return entry.ip
+	}
+	if lookup == nil {
+		lookup = OutboundIP
+	}
+	// name of the server is resolved without the lock, so it does not block reloads and reports
+	ip, err := lookup(serverURL)
+	found := &realIPEntry{}
+	if err != nil {
+		fmt.Printf("X-Real-IP is not sent: %s\n", err.Error())
+		found.backoff = realIPMinBackoff
+		if ok {
+			found.backoff = entry.backoff * 2
+			if found.backoff > realIPMaxBackoff {
+				found.backoff = realIPMaxBackoff
+			}
+		}
+		found.next = time.Now().Add(found.backoff)
+	} else {
+		found.ip = ip.String()
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.realIPs == nil {
+		r.realIPs = make(map[string]*realIPEntry)
+	}
+	r.realIPs[serverURL] = found
+	return found.ip
+}
+
+// context returns context of sending which is done when the sender is stopped
+// and FlushTimeout is over
+func (r *MetricSender) context() context.Context {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.sendCtx == nil {
+		return context.Background()
+	}
+	return r.sendCtx
+}
+
+// sign sets HashSHA256 header of request with body if Key is set
+func (r *MetricSender) sign(req *resty.Request, body []byte) {
+	key := r.key()
+	if key == "" {
+		return
+	}
+	encoder := hmac.New(sha256.New, []byte(key))
+	encoder.Write(body)
+	v := encoder.Sum(nil)
+	req.SetHeader("HashSHA256", base64.RawURLEncoding.EncodeToString(v[:]))
+}
+
+// Ping checks that server is healthy, the request is signed, because server with key
+// rejects requests without sign
+func (r *MetricSender) Ping(serverURL string) error {
+	req := r.Client.R().SetContext(r.context())
+	r.sign(req, nil)
+	resp, err := req.Get(fmt.Sprintf("%s/ping", serverURL))
 	if err != nil {
 		return err
 	}
-	req := r.Client.R().SetBody(s).SetHeader("Content-Type", "application/json")
-	if *AgentKey != "" {
-		encoder := hmac.New(sha256.New, []byte(*AgentKey))
-		encoder.Write([]byte(s))
-		v := encoder.Sum(nil)
-		req.SetHeader("HashSHA256", base64.RawURLEncoding.EncodeToString(v[:]))
-	}
-	var resp *resty.Response
-	f := func() error {
-		resp, err = req.Post(
-			url,
-		)
+	if resp.StatusCode() != http.StatusOK {
+		return general.NewHTTPStatusError(resp.StatusCode(), resp.Header(), resp.String())
+	}
+	if b := r.breaker(serverURL); b != nil {
+		// server is alive, so requests to it can be sent again
+		b.Record(true)
+	}
+	return nil
+}
+
+// ProbeInterval checks the primary server periodically to switch back to it after failover
+func (r *MetricSender) ProbeInterval() {
+	ticker := time.NewTicker(r.Servers.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Servers.Probe(r.Ping)
+		case <-r.Done:
+			return
+		}
+	}
+}
+
+// PrepareBatch takes all metrics collected since the previous report
+func (r *MetricSender) PrepareBatch() []general.Metrics {
+	if r.Buffer == nil {
+		return nil
+	}
+	r.Telemetry.Queue(r.Queue)
+	r.m.Lock()
+	filter := r.MetricFilter
+	labels := r.Labels
+	r.m.Unlock()
+	metrics := r.Buffer.FlushMatching(filter)
+	result := make([]general.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		metric.Labels = mergeLabels(labels, metric.Labels)
+		result = append(result, metric)
+	}
+	return result
+}
+
+// mergeLabels adds labels of metric set by collector to labels of sender, the ones of metric
+// win. Labels map is replaced on reload and never changed, so it is shared by metrics without own ones.
+func mergeLabels(labels map[string]string, own map[string]string) map[string]string {
+	if len(own) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(own))
+	for name, value := range labels {
+		merged[name] = value
+	}
+	for name, value := range own {
+		merged[name] = value
+	}
+	return merged
+}
+
+// SetLabels replaces labels attached to metrics starting from the next report
+func (r *MetricSender) SetLabels(labels map[string]string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.Labels = labels
+}
+
+// RunSendWorkers starts RateLimit workers which send batches pushed to the returned channel,
+// so no more than RateLimit requests are in flight at the same time.
+// Workers exit when the channel is closed and all pushed batches are processed.
+func (r *MetricSender) RunSendWorkers(wg *sync.WaitGroup) chan<- []general.Metrics {
+	limit := r.rateLimit()
+	jobs := make(chan []general.Metrics, limit)
+	for i := 1; i <= limit; i++ {
+		wg.Add(1)
+		go r.SendWorker(i, jobs, wg)
+	}
+	return jobs
+}
+
+func (r *MetricSender) rateLimit() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.RateLimit > 0 {
+		return r.RateLimit
+	}
+	return 1
+}
+
+func (r *MetricSender) SendWorker(id int, jobs <-chan []general.Metrics, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for metrics := range jobs {
+		err := r.Deliver(metrics)
+		if err != nil {
+			fmt.Printf("Worker %d: %s\n", id, err.Error())
+			continue
+		}
+		fmt.Printf("Worker %d: batch has been processed\n", id)
+	}
+}
+
+// Deliver sends batch to the server. If Queue is set, a batch which cannot be sent is spooled
+// to the disk, and while the queue is not empty new batches are put behind the spooled ones,
+// so the server gets all batches in the order they were prepared. Deliveries are serialized
+// only while the queue is not empty, so a fresh batch of one worker never overtakes a batch
+// spooled by another one, otherwise RateLimit batches are sent at the same time.
+// Without Queue counters
+// of a batch which cannot be sent are put back to the buffer, so the next report carries
+// their increase since the last successful one, and the other metrics are dropped.
+// Batches rejected by the server are dropped, because sending them again does not help.
+func (r *MetricSender) Deliver(metrics []general.Metrics) error {
+	if r.Queue == nil {
+		err := r.SendMetrics(metrics)
+		if err != nil {
+			r.Telemetry.Dropped(len(metrics) - r.restoreCounters(metrics, err))
+		}
 		return err
 	}
-	err = general.RetryCode(f, syscall.ECONNREFUSED)
+	if r.Queue.Len() == 0 {
+		err := r.SendMetrics(metrics)
+		if err == nil {
+			return nil
+		}
+		if isRejected(err) {
+			r.Telemetry.Dropped(len(metrics))
+			return err
+		}
+		fmt.Printf("Batch is put to the queue, reason: %s\n", err.Error())
+		r.deliverM.Lock()
+		defer r.deliverM.Unlock()
+		return r.push(metrics)
+	}
+	r.deliverM.Lock()
+	defer r.deliverM.Unlock()
+	err := r.push(metrics)
 	if err != nil {
-		err = fmt.Errorf("request cannot be precossed, something is wrong: %w", err)
 		return err
 	}
-	if resp.StatusCode() != http.StatusOK && resp.StatusCode() != http.StatusCreated {
-		return fmt.Errorf("something wrong with resp - '%v', status code - %v", resp, resp.StatusCode())
+	count, err := r.Queue.Replay(func(metrics []general.Metrics) error {
+		err := r.SendMetrics(metrics)
+		if isRejected(err) {
+			fmt.Printf("Batch from the queue has been dropped: %s\n", err.Error())
+			r.Telemetry.Dropped(len(metrics))
+			return nil
+		}
+		return err
+	})
+	fmt.Printf("%d batches have been replayed from the queue, %d are left\n", count, r.Queue.Len())
+	if err != nil {
+		// batch is kept in the queue, it will be replayed with the next one
+		fmt.Printf("Replay of queue has been stopped: %s\n", err.Error())
 	}
-	fmt.Printf("Success: %v\n", resp.StatusCode())
 	return nil
 }
 
-func (r *MetricSender) ReportLogic(m *map[string]interface{}, countOfUpdate *atomic.Int64) {
-	metrics := make([]general.Metrics, 0)
-	var err error
-	for _, v := range *r.ListMetrics {
-		casted, ok := (*m)[v].(float64)
-		if ok {
-			metrics = append(
-				metrics,
-				general.Metrics{ID: v, MType: GAUGE, Value: &casted},
-			)
-		} else {
-			err = errors.Join(err, fmt.Errorf("cannot be cast to float64, wrong type for '%s' metric name with value '%v'", v, (*m)[v]))
+// restoreCounters puts counters of the batch which has failed back to the buffer
+// and returns their number, nothing is restored if the server has rejected the batch
+func (r *MetricSender) restoreCounters(metrics []general.Metrics, err error) int {
+	if r.Buffer == nil || isRejected(err) {
+		return 0
+	}
+	counters := make([]general.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if metric.MType == COUNTER {
+			counters = append(counters, metric)
 		}
 	}
+	r.Buffer.Add(counters...)
+	return len(counters)
+}
+
+func (r *MetricSender) push(metrics []general.Metrics) error {
+	err := r.Queue.Push(metrics)
 	if err != nil {
-		fmt.Println(err.Error())
-		return
+		r.Telemetry.Dropped(len(metrics))
 	}
-	delta := countOfUpdate.Load()
-	metrics = append(
-		metrics,
-		general.Metrics{ID: "PollCount", MType: COUNTER, Delta: &delta},
-	)
-	c := rand.Float64()
-	metrics = append(
-		metrics,
-		general.Metrics{ID: "RandomValue", MType: GAUGE, Value: &c},
-	)
-	err = r.SendMetrics(metrics)
-	if err != nil {
-		fmt.Println(err.Error())
-		return
+	return err
+}
+
+// isRejected tells if server has refused the batch itself, e.g. because it is malformed
+// or its sign is wrong, rather than failed to process it
+func isRejected(err error) bool {
+	var statusErr *general.HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return false
 	}
-	fmt.Println("All metrics successfully sent")
+	code := statusErr.StatusCode
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
 }
 
-func (r *MetricSender) ReportInterval(
-	memInfo *map[string]interface{},
-	countOfUpdate *atomic.Int64,
-) {
-	tickerReportInterval := time.NewTicker(time.Duration(*r.ReportIntervalTime) * time.Second)
+func (r *MetricSender) ReportInterval() {
+	var sendersWG sync.WaitGroup
+	jobs := r.RunSendWorkers(&sendersWG)
+	defer func() {
+		close(jobs)
+		sendersWG.Wait()
+	}()
+	reportInterval := r.reportInterval()
+	rateLimit := r.rateLimit()
+	tickerReportInterval := time.NewTicker(time.Duration(reportInterval) * time.Second)
 	defer tickerReportInterval.Stop()
 	for {
 		select {
+		case <-r.reload:
+			if r.reportInterval() != reportInterval {
+				reportInterval = r.reportInterval()
+				tickerReportInterval.Reset(time.Duration(reportInterval) * time.Second)
+				fmt.Printf("Report interval has been changed to %d seconds\n", reportInterval)
+			}
+			if r.rateLimit() != rateLimit {
+				// batches already passed to the old workers are sent before they exit
+				close(jobs)
+				sendersWG.Wait()
+				jobs = r.RunSendWorkers(&sendersWG)
+				rateLimit = r.rateLimit()
+				fmt.Printf("Rate limit has been changed to %d\n", rateLimit)
+			}
 		case <-tickerReportInterval.C:
-			r.M.Lock()
-			r.ReportLogic(memInfo, countOfUpdate)
-			r.M.Unlock()
-			countOfUpdate.Swap(int64(0))
+			metrics := r.PrepareBatch()
+			if len(metrics) == 0 {
+				fmt.Println("There is nothing to report")
+				continue
+			}
+			// sending is done by workers, so a slow server never blocks polling
+			select {
+			case jobs <- metrics:
+			case <-r.Done:
+				// workers are busy, the batch is sent by Flush
+				r.unsent = metrics
+				return
+			}
 			fmt.Println("Done ReportInterval!")
 		case <-r.Done:
 			return
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -42,9 +43,9 @@ func GetHandler(s *string, t *testing.T) func(w http.ResponseWriter, request *ht
 	}
 }
 
-func TestMetricSender_PollInterval(t *testing.T) {
+func TestMetricSender_Run(t *testing.T) {
 	type fields struct {
-		ListMetrics *[]string
+		Collectors []string
 	}
 	if AgentKey == nil {
 		ParseArgsClient()
@@ -58,7 +59,7 @@ func TestMetricSender_PollInterval(t *testing.T) {
 		{
 			name: "1",
 			fields: fields{
-				ListMetrics: &ListMetrics,
+				Collectors: []string{"runtime", "memory", "cpu"},
 			},
 			keyForHashing: "",
 			isHashed:      false,
@@ -66,7 +67,7 @@ func TestMetricSender_PollInterval(t *testing.T) {
 		{
 			name: "1",
 			fields: fields{
-				ListMetrics: &ListMetrics,
+				Collectors: []string{"runtime", "memory", "cpu"},
 			},
 			keyForHashing: "blabla",
 			isHashed:      true,
@@ -74,7 +75,6 @@ func TestMetricSender_PollInterval(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			AgentKey = &tt.keyForHashing
 			s := ""
 			fmt.Println("Mocking server...")
 			server := httptest.NewServer(
@@ -83,21 +83,19 @@ func TestMetricSender_PollInterval(t *testing.T) {
 				),
 			)
 			defer server.Close()
-			PullIntervalTime := 1
-			ReportIntervalTime := 2
-			var m sync.Mutex
+			collectors, err := NewCollectors(&ClientConfig{}, tt.fields.Collectors, nil, 2)
+			if err != nil {
+				panic(err)
+			}
 			done := make(chan bool)
-			goPull := make(chan struct{})
 			var wg sync.WaitGroup
 			r := MetricSender{
 				URL:                server.URL,
-				ListMetrics:        tt.fields.ListMetrics,
 				Client:             resty.New(),
-				ReportIntervalTime: &PullIntervalTime,
-				PollIntervalTime:   &ReportIntervalTime,
+				ReportIntervalTime: 1,
+				Key:                tt.keyForHashing,
+				Collectors:         collectors,
 				Done:               done,
-				M:                  &m,
-				GoPull:             goPull,
 				WG:                 &wg,
 			}
 			wg.Add(1)
@@ -106,10 +104,18 @@ func TestMetricSender_PollInterval(t *testing.T) {
 			close(done)
 			wg.Wait()
 			for _, v := range ListMetrics {
+				if _, ok := memStatsCounters[v]; ok {
+					assert.Contains(t, s, fmt.Sprintf("id: '%s', type: 'counter', value:", v))
+					continue
+				}
 				assert.Contains(t, s, fmt.Sprintf("id: '%s', type: 'gauge', value:", v))
 			}
 			assert.Contains(t, s, "id: 'RandomValue', type: 'gauge', value:")
-			assert.Contains(t, s, "id: 'PollCount', type: 'counter', value: '2'")
+			assert.Contains(t, s, "id: 'TotalMemory', type: 'gauge', value:")
+			assert.Contains(t, s, "id: 'FreeMemory', type: 'gauge', value:")
+			assert.Contains(t, s, "id: 'CPUutilization1', type: 'gauge', value:")
+			assert.Contains(t, s, "id: 'TotalCPUutilization', type: 'gauge', value:")
+			assert.Contains(t, s, "id: 'PollCount', type: 'counter', value: '1'")
 			if tt.isHashed {
 				assert.Equal(t, s[len(s)-len("||Hashed"):], "||Hashed")
 			}
@@ -118,35 +124,25 @@ func TestMetricSender_PollInterval(t *testing.T) {
 }
 
 func TestMetricSender_ReportInterval(t *testing.T) {
-	type fields struct {
-		ListMetrics *[]string
-	}
 	if AgentKey == nil {
 		ParseArgsClient()
 	}
 	type args struct {
-		a             *map[string]interface{}
-		countOfUpdate int64
+		metrics []general.Metrics
 	}
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
+		name string
+		args args
 	}{
 		{
 			name: "1",
-			fields: fields{
-				&[]string{
-					"Alloc", "Sys",
-				},
-			},
 			args: args{
-				a: &map[string]interface{}{
-					"Alloc":   1245.0,
-					"Sys":     544.0,
-					"Lookups": 10.0,
+				metrics: []general.Metrics{
+					gauge("Alloc", 1245.0),
+					gauge("Sys", 544.0),
+					counter("PollCount", 2),
+					counter("PollCount", 3),
 				},
-				countOfUpdate: int64(5),
 			},
 		},
 	}
@@ -160,30 +156,235 @@ func TestMetricSender_ReportInterval(t *testing.T) {
 				),
 			)
 			defer server.Close()
-			ReportIntervalTime := 1
-			PollIntervalTime := 1
 			done := make(chan bool)
-			goPull := make(chan struct{})
-			var m sync.Mutex
 			r := MetricSender{
 				URL:                server.URL,
-				ListMetrics:        tt.fields.ListMetrics,
 				Client:             resty.New(),
-				ReportIntervalTime: &ReportIntervalTime,
-				PollIntervalTime:   &PollIntervalTime,
+				ReportIntervalTime: 1,
+				Buffer:             NewMetricBuffer(),
 				Done:               done,
-				M:                  &m,
-				GoPull:             goPull,
 			}
-			var count atomic.Int64
-			count.Swap(tt.args.countOfUpdate)
-			go r.ReportInterval(tt.args.a, &count)
-			time.Sleep(time.Duration(*r.ReportIntervalTime)*time.Second + time.Millisecond*50)
+			r.Buffer.Add(tt.args.metrics...)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.ReportInterval()
+			}()
+			time.Sleep(time.Duration(r.ReportIntervalTime)*time.Second + time.Millisecond*50)
 			close(done)
+			wg.Wait()
 			assert.Contains(t, s, "id: 'Alloc', type: 'gauge', value: '1245'")
 			assert.Contains(t, s, "id: 'Sys', type: 'gauge', value: '544'")
 			assert.Contains(t, s, "id: 'PollCount', type: 'counter', value: '5'")
-			assert.Contains(t, s, "id: 'RandomValue', type: 'gauge', value:")
+		})
+	}
+}
+
+func TestMetricSender_RunSendWorkers(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	tests := []struct {
+		name         string
+		rateLimit    int
+		countBatches int
+		wantInFlight int64
+	}{
+		{
+			name:         "one_worker",
+			rateLimit:    1,
+			countBatches: 4,
+			wantInFlight: 1,
+		},
+		{
+			name:         "several_workers",
+			rateLimit:    3,
+			countBatches: 9,
+			wantInFlight: 3,
+		},
+		{
+			name:         "more_workers_than_batches",
+			rateLimit:    5,
+			countBatches: 2,
+			wantInFlight: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight atomic.Int64
+			var maxInFlight atomic.Int64
+			var countRequests atomic.Int64
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, request *http.Request) {
+						current := inFlight.Add(1)
+						for {
+							old := maxInFlight.Load()
+							if current <= old || maxInFlight.CompareAndSwap(old, current) {
+								break
+							}
+						}
+						time.Sleep(100 * time.Millisecond)
+						countRequests.Add(1)
+						inFlight.Add(-1)
+					},
+				),
+			)
+			defer server.Close()
+			r := MetricSender{
+				URL:       server.URL,
+				Client:    resty.New(),
+				RateLimit: tt.rateLimit,
+			}
+			var wg sync.WaitGroup
+			jobs := r.RunSendWorkers(&wg)
+			for i := 0; i < tt.countBatches; i++ {
+				v := float64(i)
+				jobs <- []general.Metrics{{ID: "Alloc", MType: GAUGE, Value: &v}}
+			}
+			close(jobs)
+			wg.Wait()
+			assert.Equal(t, int64(tt.countBatches), countRequests.Load())
+			assert.Equal(t, tt.wantInFlight, maxInFlight.Load())
+		})
+	}
+}
+
+func TestMetricSender_Reload(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	s := ""
+	server := httptest.NewServer(http.HandlerFunc(GetHandler(&s, t)))
+	defer server.Close()
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	r := MetricSender{
+		URL:                server.URL,
+		Client:             resty.New(),
+		ReportIntervalTime: 60,
+		Buffer:             NewMetricBuffer(),
+		Collectors: []ScheduledCollector{
+			{
+				Collector: &fakeCollector{name: "a", metrics: []general.Metrics{gauge("A", 1)}},
+				Interval:  time.Hour,
+			},
+		},
+		Done: done,
+		WG:   &wg,
+	}
+	assert.Error(t, r.Reload(nil, nil))
+	r.Buffer.Add(counter("Pending", 3))
+	wg.Add(1)
+	r.Run()
+
+	filter := NewFilter("", "B")
+	err := r.Reload(
+		[]ScheduledCollector{
+			{
+				Collector: &fakeCollector{name: "a", metrics: []general.Metrics{gauge("NewA", 1)}},
+				Interval:  10 * time.Millisecond,
+			},
+			{
+				Collector: &fakeCollector{name: "b", metrics: []general.Metrics{gauge("B", 1)}},
+				Interval:  10 * time.Millisecond,
+			},
+		},
+		&filter,
+	)
+	assert.NoError(t, err)
+	// key and report interval are changed like after reload of config file
+	r.SetSendOptions("secret", 1, 2)
+	time.Sleep(time.Second + 300*time.Millisecond)
+	close(done)
+	wg.Wait()
+	assert.Contains(t, s, "id: 'Pending', type: 'counter', value: '3'")
+	assert.Contains(t, s, "id: 'NewA', type: 'gauge', value: '1'")
+	assert.Contains(t, s, "Hashed")
+	assert.NotContains(t, s, "id: 'A'")
+	assert.NotContains(t, s, "id: 'B'")
+	assert.Error(t, r.Reload(nil, nil))
+}
+
+// pollCounter reports PollCount incremented by every call like runtime collector does
+type pollCounter struct {
+	calls atomic.Int64
+}
+
+func (c *pollCounter) Name() string {
+	return "poll"
+}
+
+func (c *pollCounter) Collect(ctx context.Context) ([]general.Metrics, error) {
+	c.calls.Add(1)
+	return []general.Metrics{counter("PollCount", 1)}, nil
+}
+
+func TestMetricSender_Flush(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	tests := []struct {
+		name         string
+		status       int
+		flushTimeout time.Duration
+		wantSent     bool
+		wantErr      bool
+	}{
+		{name: "flushed", status: http.StatusOK, flushTimeout: 2 * time.Second, wantSent: true},
+		{name: "disabled", status: http.StatusOK, flushTimeout: 0, wantSent: false},
+		{name: "deadline", status: http.StatusServiceUnavailable, flushTimeout: 300 * time.Millisecond, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+				var metrics []general.Metrics
+				assert.NoError(t, json.NewDecoder(request.Body).Decode(&metrics))
+				w.WriteHeader(tt.status)
+				if tt.status != http.StatusOK {
+					return
+				}
+				for _, m := range metrics {
+					if m.ID == "PollCount" {
+						received.Add(*m.Delta)
+					}
+				}
+			}))
+			defer server.Close()
+			collector := &pollCounter{}
+			done := make(chan bool)
+			var wg sync.WaitGroup
+			// nothing is reported by the ticker before stop
+			r := MetricSender{
+				URL:                server.URL,
+				Client:             resty.New(),
+				ReportIntervalTime: 60,
+				Retry:              &general.RetryPolicy{MaxAttempts: 100, BaseDelay: 50 * time.Millisecond},
+				Collectors:         []ScheduledCollector{{Collector: collector, Interval: 10 * time.Millisecond}},
+				FlushTimeout:       tt.flushTimeout,
+				Done:               done,
+				WG:                 &wg,
+			}
+			wg.Add(1)
+			r.Run()
+			time.Sleep(100 * time.Millisecond)
+			close(done)
+			start := time.Now()
+			wg.Wait()
+			assert.Less(t, time.Since(start), tt.flushTimeout+time.Second)
+			if tt.wantSent {
+				// the final poll is reported too
+				assert.Equal(t, collector.calls.Load(), received.Load())
+			} else {
+				assert.Equal(t, int64(0), received.Load())
+			}
+			if tt.wantErr {
+				assert.Error(t, r.FlushErr())
+			} else {
+				assert.NoError(t, r.FlushErr())
+			}
 		})
 	}
 }
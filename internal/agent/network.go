@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+// NetworkCollector reports traffic of network interfaces as counters like 'NetBytesSent'
+// labeled by 'interface'. Values are deltas since the previous call, so the first call
+// reports nothing for an interface. Interfaces filter is applied to interface names.
+type NetworkCollector struct {
+	Interfaces Filter
+	counters   *counterTracker
+	ioCounters func(ctx context.Context, pernic bool) ([]psnet.IOCountersStat, error)
+}
+
+// NetInterfaceLabel is a label of metrics which tells network interface
+const NetInterfaceLabel = "interface"
+
+func init() {
+	RegisterCollector("network", func(cfg *ClientConfig) (Collector, error) {
+		return NewNetworkCollector(NewFilter(cfg.NetInclude, cfg.NetExclude)), nil
+	})
+}
+
+func NewNetworkCollector(interfaces Filter) *NetworkCollector {
+	return &NetworkCollector{
+		Interfaces: interfaces,
+		counters:   newCounterTracker(),
+		ioCounters: psnet.IOCountersWithContext,
+	}
+}
+
+func (c *NetworkCollector) Name() string {
+	return "network"
+}
+
+func (c *NetworkCollector) Inherit(old Collector) {
+	if old, ok := old.(*NetworkCollector); ok {
+		c.counters = old.counters
+	}
+}
+
+func (c *NetworkCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	interfaces, err := c.ioCounters(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]general.Metrics, 0)
+	for _, nic := range interfaces {
+		if !c.Interfaces.Match(nic.Name) {
+			continue
+		}
+		labels := map[string]string{NetInterfaceLabel: nic.Name}
+		values := []struct {
+			id    string
+			value uint64
+		}{
+			{"NetBytesSent", nic.BytesSent},
+			{"NetBytesRecv", nic.BytesRecv},
+			{"NetPacketsSent", nic.PacketsSent},
+			{"NetPacketsRecv", nic.PacketsRecv},
+			{"NetErrorsIn", nic.Errin},
+			{"NetErrorsOut", nic.Errout},
+			{"NetDropsIn", nic.Dropin},
+			{"NetDropsOut", nic.Dropout},
+		}
+		for _, v := range values {
+			if delta, ok := c.counters.Delta(v.id+":"+nic.Name, v.value); ok {
+				metrics = append(metrics, labeled(counter(v.id, delta), labels))
+			}
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkCollector_Collect(t *testing.T) {
+	snapshots := [][]psnet.IOCountersStat{
+		{
+			{Name: "lo", BytesSent: 100, BytesRecv: 100},
+			{Name: "eth0", BytesSent: 1000, BytesRecv: 5000, PacketsSent: 10, PacketsRecv: 50, Errin: 1, Dropout: 2},
+		},
+		{
+			{Name: "lo", BytesSent: 200, BytesRecv: 200},
+			{Name: "eth0", BytesSent: 1500, BytesRecv: 9000, PacketsSent: 15, PacketsRecv: 90, Errin: 1, Dropout: 3},
+			{Name: "eth1", BytesSent: 10, BytesRecv: 10},
+		},
+		{
+			// eth0 counters have been reset, e.g. the driver was reloaded
+			{Name: "eth0", BytesSent: 300, BytesRecv: 400, PacketsSent: 3, PacketsRecv: 4},
+			{Name: "eth1", BytesSent: 30, BytesRecv: 20},
+		},
+	}
+	call := 0
+	c := NewNetworkCollector(NewFilter("", "lo"))
+	c.ioCounters = func(ctx context.Context, pernic bool) ([]psnet.IOCountersStat, error) {
+		assert.True(t, pernic)
+		return snapshots[call], nil
+	}
+	tests := []struct {
+		name string
+		want map[string]int64
+	}{
+		{
+			name: "first_call",
+			want: map[string]int64{},
+		},
+		{
+			name: "deltas",
+			want: map[string]int64{
+				"NetBytesSent{interface=eth0}":   500,
+				"NetBytesRecv{interface=eth0}":   4000,
+				"NetPacketsSent{interface=eth0}": 5,
+				"NetPacketsRecv{interface=eth0}": 40,
+				"NetErrorsIn{interface=eth0}":    0,
+				"NetErrorsOut{interface=eth0}":   0,
+				"NetDropsIn{interface=eth0}":     0,
+				"NetDropsOut{interface=eth0}":    1,
+			},
+		},
+		{
+			name: "reset_and_new_interface",
+			want: map[string]int64{
+				"NetBytesSent{interface=eth0}":   300,
+				"NetBytesRecv{interface=eth0}":   400,
+				"NetPacketsSent{interface=eth0}": 3,
+				"NetPacketsRecv{interface=eth0}": 4,
+				"NetErrorsIn{interface=eth0}":    0,
+				"NetErrorsOut{interface=eth0}":   0,
+				"NetDropsIn{interface=eth0}":     0,
+				"NetDropsOut{interface=eth0}":    0,
+				"NetBytesSent{interface=eth1}":   20,
+				"NetBytesRecv{interface=eth1}":   10,
+				"NetPacketsSent{interface=eth1}": 0,
+				"NetPacketsRecv{interface=eth1}": 0,
+				"NetErrorsIn{interface=eth1}":    0,
+				"NetErrorsOut{interface=eth1}":   0,
+				"NetDropsIn{interface=eth1}":     0,
+				"NetDropsOut{interface=eth1}":    0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := c.Collect(context.Background())
+			require.NoError(t, err)
+			call++
+			got := metricsToMap(metrics)
+			assert.Equal(t, len(tt.want), len(got))
+			for id, want := range tt.want {
+				v, ok := got[id]
+				require.True(t, ok, id)
+				assert.Equal(t, COUNTER, v.MType)
+				assert.Equal(t, want, *v.Delta, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/shirou/gopsutil/v3/common"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+)
+
+// pressureResources are files of /proc/pressure, 'full' line of cpu is all zeros on old kernels
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// PressureCollector reports saturation of the host: pressure stall information of Linux
+// as gauges like 'PressureSomeAvg10' and 'PressureFullAvg300' (percents of time tasks were
+// stalled) and counters like 'PressureSomeTotal' (microseconds of stall since the previous
+// call) labeled by 'resource' which is cpu, memory or io, load averages LoadAverage1,
+// LoadAverage5 and LoadAverage15, and Uptime in seconds. Files are read from ProcRoot.
+// Kernels without PSI report only load averages and uptime.
+type PressureCollector struct {
+	ProcRoot string
+	counters *counterTracker
+	loadAvg  func(ctx context.Context) (*load.AvgStat, error)
+	uptime   func(ctx context.Context) (uint64, error)
+}
+
+// PressureResourceLabel is a label of metrics which tells resource tasks were stalled on
+const PressureResourceLabel = "resource"
+
+func init() {
+	RegisterCollector("pressure", func(cfg *ClientConfig) (Collector, error) {
+		return NewPressureCollector(cfg.ProcRoot), nil
+	})
+}
+
+func NewPressureCollector(procRoot string) *PressureCollector {
+	return &PressureCollector{
+		ProcRoot: procRoot,
+		counters: newCounterTracker(),
+		loadAvg:  load.AvgWithContext,
+		uptime:   host.UptimeWithContext,
+	}
+}
+
+func (c *PressureCollector) Name() string {
+	return "pressure"
+}
+
+func (c *PressureCollector) Inherit(old Collector) {
+	if old, ok := old.(*PressureCollector); ok && old.ProcRoot == c.ProcRoot {
+		c.counters = old.counters
+	}
+}
+
+func (c *PressureCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	metrics := make([]general.Metrics, 0)
+	var rErr error
+	for _, resource := range pressureResources {
+		m, err := c.collectPressure(resource)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			rErr = errors.Join(rErr, err)
+		}
+		metrics = append(metrics, m...)
+	}
+	// gopsutil reads loadavg from the same proc root
+	ctx = context.WithValue(ctx, common.EnvKey, common.EnvMap{common.HostProcEnvKey: c.ProcRoot})
+	avg, err := c.loadAvg(ctx)
+	if err != nil {
+		rErr = errors.Join(rErr, fmt.Errorf("load average cannot be read: %w", err))
+	} else {
+		metrics = append(
+			metrics,
+			gauge("LoadAverage1", avg.Load1),
+			gauge("LoadAverage5", avg.Load5),
+			gauge("LoadAverage15", avg.Load15),
+		)
+	}
+	uptime, err := c.uptime(ctx)
+	if err != nil {
+		rErr = errors.Join(rErr, fmt.Errorf("uptime cannot be read: %w", err))
+	} else {
+		metrics = append(metrics, gauge("Uptime", float64(uptime)))
+	}
+	return metrics, rErr
+}
+
+// collectPressure parses lines like 'some avg10=0.12 avg60=0.05 avg300=0.01 total=123456'
+func (c *PressureCollector) collectPressure(resource string) ([]general.Metrics, error) {
+	path := filepath.Join(c.ProcRoot, "pressure", resource)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]general.Metrics, 0)
+	labels := map[string]string{PressureResourceLabel: resource}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		kind := fields[0]
+		if kind != "some" && kind != "full" {
+			return metrics, fmt.Errorf("unknown line '%s' in '%s'", kind, path)
+		}
+		prefix := "PressureSome"
+		if kind == "full" {
+			prefix = "PressureFull"
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return metrics, fmt.Errorf("wrong field '%s' in '%s'", field, path)
+			}
+			switch key {
+			case "avg10", "avg60", "avg300":
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return metrics, fmt.Errorf("wrong value of '%s' in '%s': %w", key, path, err)
+				}
+				metrics = append(metrics, labeled(gauge(prefix+"Avg"+key[len("avg"):], v), labels))
+			case "total":
+				v, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return metrics, fmt.Errorf("wrong value of '%s' in '%s': %w", key, path, err)
+				}
+				id := prefix + "Total"
+				if delta, ok := c.counters.Delta(id+":"+resource, v); ok {
+					metrics = append(metrics, labeled(counter(id, delta), labels))
+				}
+			}
+		}
+	}
+	return metrics, nil
+}
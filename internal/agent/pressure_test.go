@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPressureCollector_Collect(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"pressure/cpu":    "some avg10=1.50 avg60=0.75 avg300=0.25 total=1000000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"pressure/memory": "some avg10=0.10 avg60=0.20 avg300=0.30 total=500\nfull avg10=0.05 avg60=0.10 avg300=0.15 total=200\n",
+		"loadavg":         "0.52 0.58 0.59 2/1234 56789\n",
+	})
+	c := NewPressureCollector(root)
+	c.uptime = func(ctx context.Context) (uint64, error) {
+		return 3600, nil
+	}
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	got := metricsToMap(metrics)
+	wantGauges := map[string]float64{
+		"PressureSomeAvg10{resource=cpu}":     1.5,
+		"PressureSomeAvg60{resource=cpu}":     0.75,
+		"PressureSomeAvg300{resource=cpu}":    0.25,
+		"PressureFullAvg10{resource=cpu}":     0,
+		"PressureSomeAvg10{resource=memory}":  0.1,
+		"PressureFullAvg300{resource=memory}": 0.15,
+		"LoadAverage1":                        0.52,
+		"LoadAverage5":                        0.58,
+		"LoadAverage15":                       0.59,
+		"Uptime":                              3600,
+	}
+	for id, value := range wantGauges {
+		require.Contains(t, got, id)
+		assert.Equal(t, GAUGE, got[id].MType)
+		assert.Equal(t, value, *got[id].Value, id)
+	}
+	// there is no io file, e.g. kernel is built without it
+	assert.NotContains(t, got, "PressureSomeAvg10{resource=io}")
+	// totals are reported starting from the second call
+	assert.NotContains(t, got, "PressureSomeTotal{resource=cpu}")
+
+	writeFixture(t, root, map[string]string{
+		"pressure/cpu":    "some avg10=2.00 avg60=1.00 avg300=0.50 total=1250000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"pressure/memory": "some avg10=0.10 avg60=0.20 avg300=0.30 total=800\nfull avg10=0.05 avg60=0.10 avg300=0.15 total=260\n",
+	})
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	got = metricsToMap(metrics)
+	wantCounters := map[string]int64{
+		"PressureSomeTotal{resource=cpu}":    250000,
+		"PressureFullTotal{resource=cpu}":    0,
+		"PressureSomeTotal{resource=memory}": 300,
+		"PressureFullTotal{resource=memory}": 60,
+	}
+	for id, delta := range wantCounters {
+		require.Contains(t, got, id)
+		assert.Equal(t, COUNTER, got[id].MType)
+		assert.Equal(t, delta, *got[id].Delta, id)
+	}
+}
+
+func TestPressureCollector_Errors(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"pressure/cpu":    "some avg10=abc avg60=0.75 avg300=0.25 total=1000000\n",
+		"pressure/memory": "some avg10=0.10 avg60=0.20 avg300=0.30 total=500\n",
+		"loadavg":         "0.52 0.58 0.59 2/1234 56789\n",
+	})
+	c := NewPressureCollector(root)
+	c.uptime = func(ctx context.Context) (uint64, error) {
+		return 0, errors.New("no sysinfo")
+	}
+	metrics, err := c.Collect(context.Background())
+	assert.Error(t, err)
+	got := metricsToMap(metrics)
+	// broken file and uptime do not affect the others
+	assert.Contains(t, got, "PressureSomeAvg10{resource=memory}")
+	assert.Contains(t, got, "LoadAverage1")
+	assert.NotContains(t, got, "Uptime")
+}
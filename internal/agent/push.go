@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/go-chi/chi"
+)
+
+// PushRouter accepts metrics from local tools in the same format as the server does
+// on /update/, /updates/ and /update/{MetricType}/{MetricName}/{MetricValue}
+// and merges them into buffer, so they are signed and sent with the next report.
+// Requests from not loopback addresses are rejected.
+func PushRouter(buffer *MetricBuffer) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/updates/", func(w http.ResponseWriter, request *http.Request) {
+		var metrics []general.Metrics
+		if !readPushBody(w, request, &metrics) {
+			return
+		}
+		pushMetrics(w, buffer, metrics)
+	})
+	r.Route(
+		"/update",
+		func(r chi.Router) {
+			r.Post("/{MetricType}/{MetricName}/{MetricValue}", func(w http.ResponseWriter, request *http.Request) {
+				metric, err := parseURLMetric(
+					chi.URLParam(request, "MetricType"),
+					chi.URLParam(request, "MetricName"),
+					chi.URLParam(request, "MetricValue"),
+				)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(err.Error()))
+					return
+				}
+				pushMetrics(w, buffer, []general.Metrics{metric})
+			})
+			r.Post("/", func(w http.ResponseWriter, request *http.Request) {
+				var metric general.Metrics
+				if !readPushBody(w, request, &metric) {
+					return
+				}
+				pushMetrics(w, buffer, []general.Metrics{metric})
+			})
+		},
+	)
+	return LoopbackOnly(r)
+}
+
+// LoopbackOnly rejects requests which have come not from the local host
+func LoopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only local requests are accepted"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckLoopbackAddress returns error if address in format <host>:<port> is not a loopback one
+func CheckLoopbackAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("push address should be a loopback one, e.g. 127.0.0.1:8081: '%s'", address)
+	}
+	return nil
+}
+
+func readPushBody(w http.ResponseWriter, request *http.Request, v interface{}) bool {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(request.Body)
+	defer request.Body.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return false
+	}
+	data := buf.Bytes()
+	if strings.Contains(request.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			data, err = io.ReadAll(gz)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return false
+		}
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Unmarshal problem: %s\n", err.Error())))
+		return false
+	}
+	return true
+}
+
+func parseURLMetric(metricType string, metricName string, metricValue string) (general.Metrics, error) {
+	switch metricType {
+	case GAUGE:
+		v, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return general.Metrics{}, fmt.Errorf("value of gauge should be float64: '%s'", metricValue)
+		}
+		return gauge(metricName, v), nil
+	case COUNTER:
+		v, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return general.Metrics{}, fmt.Errorf("value of counter should be int64: '%s'", metricValue)
+		}
+		return counter(metricName, v), nil
+	}
+	return general.Metrics{}, fmt.Errorf("wrong type of metric: '%s'", metricType)
+}
+
+func validatePushMetric(metric general.Metrics) error {
+	if metric.ID == "" {
+		return errors.New("metric id is empty")
+	}
+	switch metric.MType {
+	case GAUGE:
+		if metric.Value == nil {
+			return fmt.Errorf("gauge '%s' has no value", metric.ID)
+		}
+	case COUNTER:
+		if metric.Delta == nil {
+			return fmt.Errorf("counter '%s' has no delta", metric.ID)
+		}
+	default:
+		return fmt.Errorf("wrong type of metric: '%s'", metric.MType)
+	}
+	return nil
+}
+
+// pushMetrics puts metrics to the buffer only if all of them are valid
+func pushMetrics(w http.ResponseWriter, buffer *MetricBuffer, metrics []general.Metrics) {
+	var err error
+	for _, metric := range metrics {
+		err = errors.Join(err, validatePushMetric(metric))
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	buffer.Add(metrics...)
+	fmt.Printf("%d metrics have been pushed\n", len(metrics))
+	b, err := json.Marshal(metrics)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return b.Bytes()
+}
+
+func TestPushRouter(t *testing.T) {
+	buffer := NewMetricBuffer()
+	server := httptest.NewServer(PushRouter(buffer))
+	defer server.Close()
+	tests := []struct {
+		name           string
+		URL            string
+		body           []byte
+		isEncoded      bool
+		wantStatusCode int
+	}{
+		{
+			name:           "update",
+			URL:            "/update/",
+			body:           []byte(`{"id":"jobs","type":"counter","delta":2}`),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "updates",
+			URL:            "/updates/",
+			body:           []byte(`[{"id":"jobs","type":"counter","delta":3},{"id":"size","type":"gauge","value":1.5}]`),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "updates_encoded",
+			URL:            "/updates/",
+			body:           gzipBytes(t, []byte(`[{"id":"size","type":"gauge","value":7}]`)),
+			isEncoded:      true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "update_url_form",
+			URL:            "/update/counter/jobs/5",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "update_url_form_wrong_value",
+			URL:            "/update/counter/jobs/none",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "batch_with_wrong_metric_is_rejected",
+			URL:            "/updates/",
+			body:           []byte(`[{"id":"jobs","type":"counter","delta":100},{"id":"size","type":"gauge"}]`),
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "wrong_type",
+			URL:            "/update/",
+			body:           []byte(`{"id":"jobs","type":"histogram","delta":2}`),
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "wrong_json",
+			URL:            "/update/",
+			body:           []byte(`{"id":"jobs",`),
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resty.New().R().SetHeader("Content-Type", "application/json")
+			if tt.body != nil {
+				r.SetBody(tt.body)
+			}
+			if tt.isEncoded {
+				r.SetHeader("Content-Encoding", "gzip")
+			}
+			resp, err := r.Post(server.URL + tt.URL)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatusCode, resp.StatusCode())
+		})
+	}
+	got := metricsToMap(buffer.Flush())
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, int64(10), *got["jobs"].Delta)
+	assert.Equal(t, 7.0, *got["size"].Value)
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	handler := PushRouter(NewMetricBuffer())
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		wantStatusCode int
+	}{
+		{name: "ipv4_loopback", remoteAddr: "127.0.0.1:50000", wantStatusCode: http.StatusOK},
+		{name: "ipv6_loopback", remoteAddr: "[::1]:50000", wantStatusCode: http.StatusOK},
+		{name: "remote", remoteAddr: "10.0.0.5:50000", wantStatusCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodPost, "/update/", bytes.NewBufferString(`{"id":"a","type":"gauge","value":1}`),
+			)
+			request.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			assert.Equal(t, tt.wantStatusCode, w.Code)
+		})
+	}
+	assert.NoError(t, CheckLoopbackAddress("localhost:8081"))
+	assert.NoError(t, CheckLoopbackAddress("127.0.0.1:8081"))
+	assert.NoError(t, CheckLoopbackAddress("[::1]:8081"))
+	assert.Error(t, CheckLoopbackAddress(":8081"))
+	assert.Error(t, CheckLoopbackAddress("0.0.0.0:8081"))
+}
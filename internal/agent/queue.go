@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+const queueFileExt = ".json"
+
+// queueTmpExt is a suffix of batch being written, the file is renamed when it is complete
+const queueTmpExt = ".tmp"
+
+type queueFile struct {
+	name string
+	size int64
+}
+
+// DiskQueue keeps batches which could not be sent to the server.
+// Every batch is stored in its own file, file names grow monotonically,
+// so after restart of the agent batches are replayed in the same order.
+// When MaxSize is exceeded the oldest batches are dropped.
+// The directory and batches are accessible only by the user of the agent.
+type DiskQueue struct {
+	Dir     string
+	MaxSize int64
+	m       sync.Mutex
+	replayM sync.Mutex
+	files   []queueFile
+	size    int64
+	seq     uint64
+	dropped int64
+}
+
+func NewDiskQueue(dir string, maxSize int64) (*DiskQueue, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("max size of queue should be positive: %d", maxSize)
+	}
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{Dir: dir, MaxSize: maxSize}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasSuffix(name, queueTmpExt) {
+			// batch has not been written completely before the agent stopped
+			err = os.Remove(filepath.Join(dir, name))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			fmt.Printf("Incomplete batch '%s' has been removed from queue dir\n", name)
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, queueFileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileExt), 10, 64)
+		if err != nil {
+			fmt.Printf("Skip unknown file in queue dir: '%s'\n", name)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.files = append(q.files, queueFile{name: name, size: info.Size()})
+		q.size += info.Size()
+		if seq > q.seq {
+			q.seq = seq
+		}
+	}
+	sort.Slice(q.files, func(i, j int) bool { return q.files[i].name < q.files[j].name })
+	fmt.Printf("Queue '%s' has been opened with %d batches\n", dir, len(q.files))
+	return q, nil
+}
+
+func (q *DiskQueue) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.files)
+}
+
+func (q *DiskQueue) Size() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.size
+}
+
+// Dropped returns number of batches dropped since the queue was opened,
+// because the queue was full or the batches were corrupted
+func (q *DiskQueue) Dropped() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.dropped
+}
+
+// Push stores batch as the newest one, dropping the oldest batches if there is no space left
+func (q *DiskQueue) Push(metrics []general.Metrics) error {
+	b, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	size := int64(len(b))
+	if size > q.MaxSize {
+		return fmt.Errorf("batch of %d bytes is bigger than queue limit %d bytes", size, q.MaxSize)
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	for len(q.files) > 0 && q.size+size > q.MaxSize {
+		oldest := q.files[0]
+		err = os.Remove(filepath.Join(q.Dir, oldest.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		q.files = q.files[1:]
+		q.size -= oldest.size
+		q.dropped++
+		fmt.Printf("Queue is full, batch '%s' has been dropped\n", oldest.name)
+	}
+	q.seq++
+	name := fmt.Sprintf("%020d%s", q.seq, queueFileExt)
+	path := filepath.Join(q.Dir, name)
+	err = os.WriteFile(path+queueTmpExt, b, 0o600)
+	if err == nil {
+		err = os.Rename(path+queueTmpExt, path)
+	}
+	if err != nil {
+		os.Remove(path + queueTmpExt)
+		return err
+	}
+	q.files = append(q.files, queueFile{name: name, size: size})
+	q.size += size
+	return nil
+}
+
+func (q *DiskQueue) remove(f queueFile) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for i, v := range q.files {
+		if v.name == f.name {
+			q.files = append(q.files[:i], q.files[i+1:]...)
+			q.size -= v.size
+			break
+		}
+	}
+	err := os.Remove(filepath.Join(q.Dir, f.name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (q *DiskQueue) oldest() (queueFile, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if len(q.files) == 0 {
+		return queueFile{}, false
+	}
+	return q.files[0], true
+}
+
+// Replay passes batches to send oldest first and removes every batch which has been sent.
+// It stops on the first error to keep the order. Only one replay is run at the same time,
+// concurrent calls return immediately.
+func (q *DiskQueue) Replay(send func([]general.Metrics) error) (int, error) {
+	if !q.replayM.TryLock() {
+		return 0, nil
+	}
+	defer q.replayM.Unlock()
+	count := 0
+	for {
+		f, ok := q.oldest()
+		if !ok {
+			return count, nil
+		}
+		b, err := os.ReadFile(filepath.Join(q.Dir, f.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return count, err
+		}
+		var metrics []general.Metrics
+		if err == nil {
+			err = json.Unmarshal(b, &metrics)
+			if err != nil {
+				fmt.Printf("Batch '%s' is corrupted and will be dropped: %s\n", f.name, err.Error())
+				q.m.Lock()
+				q.dropped++
+				q.m.Unlock()
+			} else {
+				err = send(metrics)
+				if err != nil {
+					return count, err
+				}
+				count++
+			}
+		}
+		err = q.remove(f)
+		if err != nil {
+			return count, err
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchWithDelta(delta int64) []general.Metrics {
+	return []general.Metrics{{ID: "PollCount", MType: COUNTER, Delta: &delta}}
+}
+
+func TestDiskQueue_ReplayOrder(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 1024*1024)
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, q.Push(batchWithDelta(i)))
+	}
+	assert.Equal(t, 3, q.Len())
+
+	// queue is reopened like after restart of the agent
+	q, err = NewDiskQueue(dir, 1024*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 3, q.Len())
+	require.NoError(t, q.Push(batchWithDelta(4)))
+
+	got := make([]int64, 0)
+	count, err := q.Replay(func(metrics []general.Metrics) error {
+		got = append(got, *metrics[0].Delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, []int64{1, 2, 3, 4}, got)
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(0), q.Size())
+}
+
+func TestDiskQueue_ReplayStopsOnError(t *testing.T) {
+	q, err := NewDiskQueue(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, q.Push(batchWithDelta(i)))
+	}
+	got := make([]int64, 0)
+	count, err := q.Replay(func(metrics []general.Metrics) error {
+		if *metrics[0].Delta == 2 {
+			return errors.New("server is unavailable")
+		}
+		got = append(got, *metrics[0].Delta)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []int64{1}, got)
+	assert.Equal(t, 2, q.Len())
+}
+
+func TestDiskQueue_MaxSize(t *testing.T) {
+	b, err := json.Marshal(batchWithDelta(1))
+	require.NoError(t, err)
+	batchSize := int64(len(b))
+	q, err := NewDiskQueue(t.TempDir(), 2*batchSize)
+	require.NoError(t, err)
+	for i := int64(1); i <= 5; i++ {
+		require.NoError(t, q.Push(batchWithDelta(i)))
+	}
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, 2*batchSize, q.Size())
+	got := make([]int64, 0)
+	_, err = q.Replay(func(metrics []general.Metrics) error {
+		got = append(got, *metrics[0].Delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 5}, got)
+
+	small, err := NewDiskQueue(t.TempDir(), batchSize-1)
+	require.NoError(t, err)
+	assert.Error(t, small.Push(batchWithDelta(1)))
+}
+
+func TestDiskQueue_TmpFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	q, err := NewDiskQueue(dir, 1024*1024)
+	require.NoError(t, err)
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	// batch cannot take the place of the non-empty directory, its incomplete file is removed
+	blocked := filepath.Join(dir, "00000000000000000001"+queueFileExt)
+	writeFixture(t, blocked, map[string]string{"file": ""})
+	assert.Error(t, q.Push(batchWithDelta(1)))
+	_, err = os.Stat(blocked + queueTmpExt)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, os.RemoveAll(blocked))
+
+	require.NoError(t, q.Push(batchWithDelta(2)))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	info, err = entries[0].Info()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// stale incomplete batch left by stopped agent is swept on open
+	stale := filepath.Join(dir, "00000000000000000009"+queueFileExt+queueTmpExt)
+	require.NoError(t, os.WriteFile(stale, []byte("[{"), 0o600))
+	q, err = NewDiskQueue(dir, 1024*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1, q.Len())
+	_, err = os.Stat(stale)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMetricSender_DeliverWithQueue(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var isAvailable atomic.Bool
+	var m sync.Mutex
+	got := make([]int64, 0)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				if !isAvailable.Load() {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				var buf bytes.Buffer
+				buf.ReadFrom(request.Body)
+				var metrics []general.Metrics
+				err := json.Unmarshal(buf.Bytes(), &metrics)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				m.Lock()
+				got = append(got, *metrics[0].Delta)
+				m.Unlock()
+			},
+		),
+	)
+	defer server.Close()
+	q, err := NewDiskQueue(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	r := MetricSender{
+		URL:    server.URL,
+		Client: resty.New(),
+		Queue:  q,
+	}
+	assert.NoError(t, r.Deliver(batchWithDelta(1)))
+	assert.NoError(t, r.Deliver(batchWithDelta(2)))
+	assert.Equal(t, 2, q.Len())
+	isAvailable.Store(true)
+	assert.NoError(t, r.Deliver(batchWithDelta(3)))
+	assert.Equal(t, 0, q.Len())
+	assert.NoError(t, r.Deliver(batchWithDelta(4)))
+	assert.Equal(t, []int64{1, 2, 3, 4}, got)
+}
+
+func TestMetricSender_DeliverWithQueueOrder(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var requests atomic.Int64
+	var m sync.Mutex
+	got := make([]int64, 0)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				if requests.Add(1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				var metrics []general.Metrics
+				if err := json.NewDecoder(request.Body).Decode(&metrics); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				m.Lock()
+				got = append(got, *metrics[0].Delta)
+				m.Unlock()
+			},
+		),
+	)
+	defer server.Close()
+	q, err := NewDiskQueue(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	r := MetricSender{
+		URL:       server.URL,
+		Client:    resty.New(),
+		Retry:     &general.RetryPolicy{MaxAttempts: 1},
+		RateLimit: 3,
+		Queue:     q,
+	}
+	var wg sync.WaitGroup
+	jobs := r.RunSendWorkers(&wg)
+	jobs <- batchWithDelta(1)
+	assert.Eventually(t, func() bool { return q.Len() == 1 }, time.Second, time.Millisecond)
+	// workers pick up their batches at the same time while the first one is in the queue
+	jobs <- batchWithDelta(2)
+	jobs <- batchWithDelta(3)
+	close(jobs)
+	wg.Wait()
+	// the spooled batch is sent before the fresh ones of the other workers
+	require.Len(t, got, 3)
+	assert.Equal(t, int64(1), got[0])
+	assert.ElementsMatch(t, []int64{1, 2, 3}, got)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestMetricSender_DeliverWithQueueConcurrently(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var inFlight, maxInFlight atomic.Int64
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(200 * time.Millisecond)
+			},
+		),
+	)
+	defer server.Close()
+	q, err := NewDiskQueue(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	r := MetricSender{
+		URL:       server.URL,
+		Client:    resty.New(),
+		Retry:     &general.RetryPolicy{MaxAttempts: 1},
+		RateLimit: 3,
+		Queue:     q,
+	}
+	var wg sync.WaitGroup
+	jobs := r.RunSendWorkers(&wg)
+	for i := int64(1); i <= 3; i++ {
+		jobs <- batchWithDelta(i)
+	}
+	close(jobs)
+	wg.Wait()
+	// the queue is empty, so batches are not serialized and RateLimit is kept
+	assert.Equal(t, int64(3), maxInFlight.Load())
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestMetricSender_DeliverRejected(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var status atomic.Int64
+	status.Store(http.StatusServiceUnavailable)
+	var requests atomic.Int64
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(int(status.Load()))
+			},
+		),
+	)
+	defer server.Close()
+	q, err := NewDiskQueue(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	r := MetricSender{
+		URL:    server.URL,
+		Client: resty.New(),
+		Retry:  &general.RetryPolicy{MaxAttempts: 2},
+		Queue:  q,
+	}
+	// unavailable server is requested again and the batch is queued
+	assert.NoError(t, r.Deliver(batchWithDelta(1)))
+	assert.Equal(t, int64(2), requests.Load())
+	assert.Equal(t, 1, q.Len())
+
+	// rejected batches are not queued and are dropped from the queue
+	status.Store(http.StatusBadRequest)
+	assert.NoError(t, r.Deliver(batchWithDelta(2)))
+	assert.Equal(t, 0, q.Len())
+	assert.Error(t, r.Deliver(batchWithDelta(3)))
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestMetricSender_DeliverRestoresCounters(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var status atomic.Int64
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				w.WriteHeader(int(status.Load()))
+			},
+		),
+	)
+	defer server.Close()
+	r := MetricSender{
+		URL:    server.URL,
+		Client: resty.New(),
+		Retry:  &general.RetryPolicy{MaxAttempts: 1},
+		Buffer: NewMetricBuffer(),
+	}
+	assert.Error(t, r.Deliver([]general.Metrics{counter("Mallocs", 5), gauge("Alloc", 1)}))
+	// the next report carries increase since the last successful one
+	r.Buffer.Add(counter("Mallocs", 3))
+	got := metricsToMap(r.Buffer.Flush())
+	assert.Equal(t, int64(8), *got["Mallocs"].Delta)
+	assert.NotContains(t, got, "Alloc")
+
+	// rejected batch is dropped
+	status.Store(http.StatusBadRequest)
+	assert.Error(t, r.Deliver([]general.Metrics{counter("Mallocs", 5)}))
+	assert.Equal(t, 0, r.Buffer.Len())
+}
+
+func TestMetricSender_Breaker(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var requests atomic.Int64
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		),
+	)
+	defer server.Close()
+	r := MetricSender{
+		URL:              server.URL,
+		Client:           resty.New(),
+		Retry:            &general.RetryPolicy{MaxAttempts: 1},
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+	}
+	for i := 0; i < 5; i++ {
+		assert.Error(t, r.SendMetrics(batchWithDelta(1)))
+	}
+	assert.Equal(t, int64(2), requests.Load())
+	assert.ErrorIs(t, r.SendMetrics(batchWithDelta(1)), general.ErrCircuitOpen)
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// OutboundIP returns local address the agent reaches server with. UDP socket is only
+// connected to find the route, so nothing is sent to the server.
+func OutboundIP(serverURL string) (net.IP, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("route to '%s' cannot be found: %w", serverURL, err)
+	}
+	defer conn.Close()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected local address '%s'", conn.LocalAddr())
+	}
+	return addr.IP, nil
+}
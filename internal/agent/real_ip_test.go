@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboundIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4", url: "http://127.0.0.1:8080", want: "127.0.0.1"},
+		{name: "default_port", url: "https://127.0.0.1", want: "127.0.0.1"},
+		{name: "wrong_url", url: "http://[::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := OutboundIP(tt.url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ip.String())
+		})
+	}
+}
+
+func TestOutboundIP_IPv6(t *testing.T) {
+	ip, err := OutboundIP("http://[::1]:8080")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %s", err.Error())
+	}
+	assert.Equal(t, "::1", ip.String())
+}
+
+func TestMetricSender_RealIP(t *testing.T) {
+	lookups := 0
+	started := make(chan bool)
+	release := make(chan bool)
+	r := MetricSender{}
+	r.outboundIP = func(serverURL string) (net.IP, error) {
+		lookups++
+		if lookups == 1 {
+			started <- true
+			<-release
+		}
+		return nil, errors.New("no route")
+	}
+	result := make(chan string)
+	go func() {
+		result <- r.realIP("http://server:8080")
+	}()
+	<-started
+	// sender is not locked while the address is looked for
+	r.SetLabels(nil)
+	close(release)
+	assert.Equal(t, "", <-result)
+
+	// failure is cached till the backoff is over
+	assert.Equal(t, "", r.realIP("http://server:8080"))
+	assert.Equal(t, 1, lookups)
+	assert.Equal(t, realIPMinBackoff, r.realIPs["http://server:8080"].backoff)
+
+	r.realIPs["http://server:8080"].next = time.Now()
+	assert.Equal(t, "", r.realIP("http://server:8080"))
+	assert.Equal(t, 2, lookups)
+	assert.Equal(t, 2*realIPMinBackoff, r.realIPs["http://server:8080"].backoff)
+
+	r.outboundIP = func(serverURL string) (net.IP, error) {
+		lookups++
+		return net.ParseIP("10.0.0.5"), nil
+	}
+	r.realIPs["http://server:8080"].next = time.Now()
+	assert.Equal(t, "10.0.0.5", r.realIP("http://server:8080"))
+	assert.Equal(t, "10.0.0.5", r.realIP("http://server:8080"))
+	assert.Equal(t, 3, lookups)
+}
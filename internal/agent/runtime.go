@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+var ListMetrics = []string{
+	"Alloc",
+	"BuckHashSys",
+	"Frees",
+	"GCCPUFraction",
+	"GCSys",
+	"HeapAlloc",
+	"HeapIdle",
+	"HeapInuse",
+	"HeapObjects",
+	"HeapReleased",
+	"HeapSys",
+	"LastGC",
+	"Lookups",
+	"MCacheInuse",
+	"MCacheSys",
+	"MSpanInuse",
+	"MSpanSys",
+	"Mallocs",
+	"NextGC",
+	"NumForcedGC",
+	"NumGC",
+	"OtherSys",
+	"PauseTotalNs",
+	"StackInuse",
+	"StackSys",
+	"Sys",
+	"TotalAlloc",
+}
+
+// memStatsFields read instantaneous fields of runtime.MemStats which can be listed in RuntimeCollector.Fields
+var memStatsFields = map[string]func(m *runtime.MemStats) float64{
+	"Alloc":         func(m *runtime.MemStats) float64 { return float64(m.Alloc) },
+	"BuckHashSys":   func(m *runtime.MemStats) float64 { return float64(m.BuckHashSys) },
+	"GCCPUFraction": func(m *runtime.MemStats) float64 { return m.GCCPUFraction },
+	"GCSys":         func(m *runtime.MemStats) float64 { return float64(m.GCSys) },
+	"HeapAlloc":     func(m *runtime.MemStats) float64 { return float64(m.HeapAlloc) },
+	"HeapIdle":      func(m *runtime.MemStats) float64 { return float64(m.HeapIdle) },
+	"HeapInuse":     func(m *runtime.MemStats) float64 { return float64(m.HeapInuse) },
+	"HeapObjects":   func(m *runtime.MemStats) float64 { return float64(m.HeapObjects) },
+	"HeapReleased":  func(m *runtime.MemStats) float64 { return float64(m.HeapReleased) },
+	"HeapSys":       func(m *runtime.MemStats) float64 { return float64(m.HeapSys) },
+	"LastGC":        func(m *runtime.MemStats) float64 { return float64(m.LastGC) },
+	"MCacheInuse":   func(m *runtime.MemStats) float64 { return float64(m.MCacheInuse) },
+	"MCacheSys":     func(m *runtime.MemStats) float64 { return float64(m.MCacheSys) },
+	"MSpanInuse":    func(m *runtime.MemStats) float64 { return float64(m.MSpanInuse) },
+	"MSpanSys":      func(m *runtime.MemStats) float64 { return float64(m.MSpanSys) },
+	"NextGC":        func(m *runtime.MemStats) float64 { return float64(m.NextGC) },
+	"OtherSys":      func(m *runtime.MemStats) float64 { return float64(m.OtherSys) },
+	"StackInuse":    func(m *runtime.MemStats) float64 { return float64(m.StackInuse) },
+	"StackSys":      func(m *runtime.MemStats) float64 { return float64(m.StackSys) },
+	"Sys":           func(m *runtime.MemStats) float64 { return float64(m.Sys) },
+}
+
+// memStatsCounters read fields of runtime.MemStats which only grow since start of the process
+var memStatsCounters = map[string]func(m *runtime.MemStats) uint64{
+	"Frees":        func(m *runtime.MemStats) uint64 { return m.Frees },
+	"Lookups":      func(m *runtime.MemStats) uint64 { return m.Lookups },
+	"Mallocs":      func(m *runtime.MemStats) uint64 { return m.Mallocs },
+	"NumForcedGC":  func(m *runtime.MemStats) uint64 { return uint64(m.NumForcedGC) },
+	"NumGC":        func(m *runtime.MemStats) uint64 { return uint64(m.NumGC) },
+	"PauseTotalNs": func(m *runtime.MemStats) uint64 { return m.PauseTotalNs },
+	"TotalAlloc":   func(m *runtime.MemStats) uint64 { return m.TotalAlloc },
+}
+
+// RuntimeCollector reports Fields of runtime.MemStats together with PollCount counter
+// increased on every call and RandomValue gauge. Cumulative fields, e.g. Mallocs and NumGC,
+// are counters of increase since the previous call, the first call reports increase since
+// start of the process, so the server accumulates them across restarts of the agent.
+// The others are gauges.
+type RuntimeCollector struct {
+	Fields   []string
+	counters *counterTracker
+}
+
+func init() {
+	RegisterCollector("runtime", func(cfg *ClientConfig) (Collector, error) {
+		return NewRuntimeCollector(ListMetrics), nil
+	})
+}
+
+func NewRuntimeCollector(fields []string) *RuntimeCollector {
+	return &RuntimeCollector{Fields: fields, counters: newCounterTracker()}
+}
+
+func (c *RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c *RuntimeCollector) Inherit(old Collector) {
+	if old, ok := old.(*RuntimeCollector); ok {
+		c.counters = old.counters
+	}
+}
+
+func (c *RuntimeCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	var err error
+	metrics := make([]general.Metrics, 0, len(c.Fields)+2)
+	for _, v := range c.Fields {
+		if read, ok := memStatsCounters[v]; ok {
+			cur := read(&memStats)
+			delta, ok := c.counters.Delta(v, cur)
+			if !ok {
+				delta = int64(cur)
+			}
+			metrics = append(metrics, counter(v, delta))
+			continue
+		}
+		read, ok := memStatsFields[v]
+		if ok {
+			metrics = append(metrics, gauge(v, read(&memStats)))
+		} else {
+			err = errors.Join(err, fmt.Errorf("unknown field '%s' of runtime.MemStats", v))
+		}
+	}
+	metrics = append(
+		metrics,
+		counter("PollCount", 1),
+		gauge("RandomValue", rand.Float64()),
+	)
+	return metrics, err
+}
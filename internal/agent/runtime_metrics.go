@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"unicode"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// runtimePercentiles are reported for every histogram of runtime/metrics
+var runtimePercentiles = []struct {
+	suffix string
+	q      float64
+}{
+	{"P50", 0.5},
+	{"P95", 0.95},
+	{"P99", 0.99},
+}
+
+// GoRuntimeCollector reports all samples supported by runtime/metrics of the agent process.
+// Names are converted to CamelCase with 'Go' prefix, e.g. '/gc/heap/allocs:bytes' becomes
+// GoGcHeapAllocsBytes. Cumulative samples are counters of increase since the previous call,
+// cumulative durations in seconds are reported in microseconds ('Seconds' suffix becomes
+// 'Microseconds'). Instantaneous samples are gauges. Histograms, e.g. GC pauses and scheduler
+// latencies, are summarized into gauges GoGcPausesSecondsP50, ...P95 and ...P99 over
+// observations since the previous call. NumGoroutine and GOMAXPROCS gauges are reported too.
+type GoRuntimeCollector struct {
+	samples    []metrics.Sample
+	ids        []string
+	cumulative []bool
+	state      *goRuntimeState
+}
+
+// goRuntimeState is previous observations of cumulative samples kept across reloads
+type goRuntimeState struct {
+	counters *counterTracker
+	// floats are previous values and fractional remainders of cumulative float samples
+	floats    map[string]float64
+	remainder map[string]float64
+	// histograms are previous bucket counts
+	histograms map[string][]uint64
+}
+
+func init() {
+	RegisterCollector("goruntime", func(cfg *ClientConfig) (Collector, error) {
+		return NewGoRuntimeCollector(), nil
+	})
+}
+
+func NewGoRuntimeCollector() *GoRuntimeCollector {
+	c := &GoRuntimeCollector{
+		state: &goRuntimeState{
+			counters:   newCounterTracker(),
+			floats:     make(map[string]float64),
+			remainder:  make(map[string]float64),
+			histograms: make(map[string][]uint64),
+		},
+	}
+	for _, d := range metrics.All() {
+		if d.Kind == metrics.KindBad {
+			continue
+		}
+		name := d.Name
+		if d.Cumulative && d.Kind == metrics.KindFloat64 && strings.HasSuffix(name, "seconds") {
+			name = strings.TrimSuffix(name, "seconds") + "microseconds"
+		}
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+		c.ids = append(c.ids, runtimeMetricID(name))
+		c.cumulative = append(c.cumulative, d.Cumulative)
+	}
+	return c
+}
+
+func (c *GoRuntimeCollector) Name() string {
+	return "goruntime"
+}
+
+func (c *GoRuntimeCollector) Inherit(old Collector) {
+	if old, ok := old.(*GoRuntimeCollector); ok {
+		c.state = old.state
+	}
+}
+
+func (c *GoRuntimeCollector) Collect(ctx context.Context) ([]general.Metrics, error) {
+	metrics.Read(c.samples)
+	result := make([]general.Metrics, 0, len(c.samples)+2)
+	for i, s := range c.samples {
+		result = append(result, c.convert(c.ids[i], c.cumulative[i], s)...)
+	}
+	result = append(
+		result,
+		gauge("NumGoroutine", float64(runtime.NumGoroutine())),
+		gauge("GOMAXPROCS", float64(runtime.GOMAXPROCS(0))),
+	)
+	return result, nil
+}
+
+func (c *GoRuntimeCollector) convert(id string, cumulative bool, s metrics.Sample) []general.Metrics {
+	switch s.Value.Kind() {
+	case metrics.KindUint64:
+		v := s.Value.Uint64()
+		if !cumulative {
+			return []general.Metrics{gauge(id, float64(v))}
+		}
+		// values are accumulated since start of the process, so the first call reports all of them
+		delta, ok := c.state.counters.Delta(id, v)
+		if !ok {
+			delta = int64(v)
+		}
+		return []general.Metrics{counter(id, delta)}
+	case metrics.KindFloat64:
+		v := s.Value.Float64()
+		if !cumulative {
+			return []general.Metrics{gauge(id, v)}
+		}
+		return []general.Metrics{counter(id, c.state.floatDelta(id, v*1e6))}
+	case metrics.KindFloat64Histogram:
+		return c.state.percentiles(id, s.Value.Float64Histogram())
+	}
+	return nil
+}
+
+// floatDelta returns integer increase of the value, fraction is carried to the next call
+func (s *goRuntimeState) floatDelta(id string, cur float64) int64 {
+	prev := s.floats[id]
+	s.floats[id] = cur
+	if cur < prev {
+		prev = 0
+		s.remainder[id] = 0
+	}
+	delta := cur - prev + s.remainder[id]
+	whole := math.Floor(delta)
+	s.remainder[id] = delta - whole
+	return int64(whole)
+}
+
+// percentiles summarizes observations added to histogram since the previous call
+func (s *goRuntimeState) percentiles(id string, h *metrics.Float64Histogram) []general.Metrics {
+	counts := make([]uint64, len(h.Counts))
+	copy(counts, h.Counts)
+	prev, ok := s.histograms[id]
+	s.histograms[id] = counts
+	deltas := make([]uint64, len(counts))
+	copy(deltas, counts)
+	if ok && len(prev) == len(counts) && !histogramReset(prev, counts) {
+		for i := range deltas {
+			deltas[i] -= prev[i]
+		}
+	}
+	var total uint64
+	for _, v := range deltas {
+		total += v
+	}
+	if total == 0 {
+		return nil
+	}
+	result := make([]general.Metrics, 0, len(runtimePercentiles))
+	for _, p := range runtimePercentiles {
+		result = append(result, gauge(id+p.suffix, histogramQuantile(h.Buckets, deltas, total, p.q)))
+	}
+	return result
+}
+
+// histogramReset tells if any bucket has decreased, then all observations are new
+func histogramReset(prev, cur []uint64) bool {
+	for i := range cur {
+		if cur[i] < prev[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// histogramQuantile returns upper boundary of bucket containing q-th observation,
+// the lower one is used for the last bucket without upper boundary
+func histogramQuantile(buckets []float64, counts []uint64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, v := range counts {
+		seen += v
+		if seen < rank {
+			continue
+		}
+		upper := buckets[i+1]
+		if math.IsInf(upper, 1) {
+			return buckets[i]
+		}
+		return upper
+	}
+	return buckets[len(buckets)-1]
+}
+
+// runtimeMetricID converts names like '/sched/latencies:seconds' to GoSchedLatenciesSeconds
+func runtimeMetricID(name string) string {
+	var b strings.Builder
+	b.WriteString("Go")
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeMetricID(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "/gc/heap/allocs:bytes", want: "GoGcHeapAllocsBytes"},
+		{name: "/sched/gomaxprocs:threads", want: "GoSchedGomaxprocsThreads"},
+		{name: "/cpu/classes/gc/mark/dedicated:cpu-microseconds", want: "GoCpuClassesGcMarkDedicatedCpuMicroseconds"},
+		{name: "/memory/classes/heap/objects:bytes", want: "GoMemoryClassesHeapObjectsBytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, runtimeMetricID(tt.name))
+		})
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)}
+	tests := []struct {
+		name   string
+		counts []uint64
+		q      float64
+		want   float64
+	}{
+		{name: "first_bucket", counts: []uint64{10, 0, 0, 0}, q: 0.5, want: 1},
+		{name: "median", counts: []uint64{2, 3, 5, 0}, q: 0.5, want: 2},
+		{name: "tail", counts: []uint64{2, 3, 5, 0}, q: 0.99, want: 4},
+		{name: "unbounded_bucket", counts: []uint64{0, 0, 1, 1}, q: 0.99, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total uint64
+			for _, v := range tt.counts {
+				total += v
+			}
+			assert.Equal(t, tt.want, histogramQuantile(buckets, tt.counts, total, tt.q))
+		})
+	}
+}
+
+func TestGoRuntimeState(t *testing.T) {
+	s := NewGoRuntimeCollector().state
+	// fraction is carried to the next call
+	assert.Equal(t, int64(1), s.floatDelta("f", 1.5))
+	assert.Equal(t, int64(1), s.floatDelta("f", 2.0))
+	assert.Equal(t, int64(3), s.floatDelta("f", 5.2))
+	// reset
+	assert.Equal(t, int64(1), s.floatDelta("f", 1.0))
+
+	h := &metrics.Float64Histogram{Buckets: []float64{0, 1, 2, 3}, Counts: []uint64{5, 0, 0}}
+	got := metricsToMap(s.percentiles("H", h))
+	assert.Equal(t, 1.0, *got["HP99"].Value)
+	// only new observations are summarized
+	h.Counts = []uint64{5, 0, 4}
+	got = metricsToMap(s.percentiles("H", h))
+	assert.Equal(t, 3.0, *got["HP50"].Value)
+	assert.Empty(t, s.percentiles("H", h))
+}
+
+func TestGoRuntimeCollector(t *testing.T) {
+	c := NewGoRuntimeCollector()
+	seen := make(map[string]bool)
+	for _, id := range c.ids {
+		assert.False(t, seen[id], "duplicated id '%s'", id)
+		seen[id] = true
+	}
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	got := metricsToMap(metrics)
+	assert.Equal(t, float64(runtime.GOMAXPROCS(0)), *got["GOMAXPROCS"].Value)
+	assert.Contains(t, got, "NumGoroutine")
+	assert.Equal(t, GAUGE, got["GoSchedGoroutinesGoroutines"].MType)
+	// cumulative samples are counted from start of the process
+	require.Contains(t, got, "GoGcHeapAllocsBytes")
+	assert.Equal(t, COUNTER, got["GoGcHeapAllocsBytes"].MType)
+	assert.Positive(t, *got["GoGcHeapAllocsBytes"].Delta)
+
+	runtime.GC()
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	got = metricsToMap(metrics)
+	assert.Positive(t, *got["GoGcCyclesTotalGcCycles"].Delta)
+	require.Contains(t, got, "GoGcPausesSecondsP99")
+	assert.Equal(t, GAUGE, got["GoGcPausesSecondsP99"].MType)
+
+	// state is kept across reload
+	next := NewGoRuntimeCollector()
+	next.Inherit(c)
+	metrics, err = next.Collect(context.Background())
+	require.NoError(t, err)
+	got = metricsToMap(metrics)
+	assert.Less(t, *got["GoGcCyclesTotalGcCycles"].Delta, int64(2))
+}
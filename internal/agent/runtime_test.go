@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	for _, v := range ListMetrics {
+		_, isGauge := memStatsFields[v]
+		_, isCounter := memStatsCounters[v]
+		assert.True(t, isGauge != isCounter, v)
+	}
+	metrics, err := NewRuntimeCollector([]string{"HeapAlloc", "Unknown"}).Collect(context.Background())
+	assert.Error(t, err)
+	got := metricsToMap(metrics)
+	require.Contains(t, got, "HeapAlloc")
+	assert.Positive(t, *got["HeapAlloc"].Value)
+	assert.Equal(t, int64(1), *got["PollCount"].Delta)
+	assert.Contains(t, got, "RandomValue")
+	assert.NotContains(t, got, "Unknown")
+}
+
+func TestRuntimeCollector_Counters(t *testing.T) {
+	runtime.GC()
+	c := NewRuntimeCollector([]string{"NumGC", "Mallocs"})
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	got := metricsToMap(metrics)
+	// the first call reports increase since start of the process
+	assert.Equal(t, COUNTER, got["Mallocs"].MType)
+	assert.Positive(t, *got["Mallocs"].Delta)
+	assert.Positive(t, *got["NumGC"].Delta)
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	before := memStats.NumGC
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&memStats)
+	next := NewRuntimeCollector([]string{"NumGC", "Mallocs"})
+	// increase is kept across reload
+	next.Inherit(c)
+	metrics, err = next.Collect(context.Background())
+	require.NoError(t, err)
+	got = metricsToMap(metrics)
+	assert.GreaterOrEqual(t, *got["NumGC"].Delta, int64(memStats.NumGC-before))
+	assert.Less(t, *got["NumGC"].Delta, int64(memStats.NumGC))
+}
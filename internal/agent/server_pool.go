@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerPool chooses servers batches are sent to. URLs are ordered by priority, the first one is primary.
+// In failover mode a batch is sent to the active server, and if it fails, to the next ones in order,
+// the first server which accepts the batch becomes active. Probe switches back to the primary one.
+// In fan-out mode a batch is sent to every server, the result is defined by the primary one,
+// the others are mirrors whose failures are only logged, so a batch replayed from the queue
+// can be sent to them twice.
+type ServerPool struct {
+	URLs          []string
+	FanOut        bool
+	ProbeInterval time.Duration
+	m             sync.Mutex
+	active        int
+}
+
+func NewServerPool(urls []string, fanOut bool, probeInterval time.Duration) (*ServerPool, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("there are no server addresses")
+	}
+	return &ServerPool{URLs: urls, FanOut: fanOut, ProbeInterval: probeInterval}, nil
+}
+
+// ServerURL returns URL of server address, scheme is https if secure is set.
+// Address with explicit scheme like 'https://host:port' is kept as is.
+func ServerURL(address string, secure bool) string {
+	if strings.Contains(address, "://") {
+		return strings.TrimRight(address, "/")
+	}
+	if secure {
+		return "https://" + address
+	}
+	return "http://" + address
+}
+
+// Active returns URL of the server batches are sent to in failover mode
+func (p *ServerPool) Active() string {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.URLs[p.active]
+}
+
+// Send calls post with URLs of servers according to the mode of the pool
+func (p *ServerPool) Send(post func(url string) error) error {
+	if p.FanOut {
+		return p.fanOut(post)
+	}
+	p.m.Lock()
+	start := p.active
+	p.m.Unlock()
+	var rErr error
+	for i := 0; i < len(p.URLs); i++ {
+		idx := (start + i) % len(p.URLs)
+		err := post(p.URLs[idx])
+		if err != nil {
+			rErr = errors.Join(rErr, fmt.Errorf("server '%s': %w", p.URLs[idx], err))
+			continue
+		}
+		if idx != start {
+			p.m.Lock()
+			p.active = idx
+			p.m.Unlock()
+			fmt.Printf("Server '%s' is active now\n", p.URLs[idx])
+		}
+		return nil
+	}
+	return rErr
+}
+
+func (p *ServerPool) fanOut(post func(url string) error) error {
+	errs := make([]error, len(p.URLs))
+	var wg sync.WaitGroup
+	for i, url := range p.URLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			errs[i] = post(url)
+		}(i, url)
+	}
+	wg.Wait()
+	for i, err := range errs[1:] {
+		if err != nil {
+			fmt.Printf("Batch has not been sent to mirror '%s': %s\n", p.URLs[i+1], err.Error())
+		}
+	}
+	if errs[0] != nil {
+		return fmt.Errorf("server '%s': %w", p.URLs[0], errs[0])
+	}
+	return nil
+}
+
+// Probe checks the primary server with ping if it is not active and makes it active if it is healthy
+func (p *ServerPool) Probe(ping func(url string) error) {
+	p.m.Lock()
+	active := p.active
+	p.m.Unlock()
+	if p.FanOut || active == 0 {
+		return
+	}
+	err := ping(p.URLs[0])
+	if err != nil {
+		fmt.Printf("Primary server '%s' is still unavailable: %s\n", p.URLs[0], err.Error())
+		return
+	}
+	p.m.Lock()
+	p.active = 0
+	p.m.Unlock()
+	fmt.Printf("Primary server '%s' is active again\n", p.URLs[0])
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerPool_Send(t *testing.T) {
+	var m sync.Mutex
+	down := map[string]bool{}
+	got := make([]string, 0)
+	post := func(url string) error {
+		m.Lock()
+		defer m.Unlock()
+		got = append(got, url)
+		if down[url] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	p, err := NewServerPool([]string{"a", "b", "c"}, false, 0)
+	require.NoError(t, err)
+	assert.NoError(t, p.Send(post))
+	assert.Equal(t, []string{"a"}, got)
+
+	down["a"], down["b"] = true, true
+	got = got[:0]
+	assert.NoError(t, p.Send(post))
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+	assert.Equal(t, "c", p.Active())
+
+	// active server is tried first
+	down["a"], down["b"] = false, false
+	got = got[:0]
+	assert.NoError(t, p.Send(post))
+	assert.Equal(t, []string{"c"}, got)
+
+	down["a"], down["b"], down["c"] = true, true, true
+	got = got[:0]
+	assert.Error(t, p.Send(post))
+	assert.Equal(t, []string{"c", "a", "b"}, got)
+	assert.Equal(t, "c", p.Active())
+
+	_, err = NewServerPool(nil, false, 0)
+	assert.Error(t, err)
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		address string
+		secure  bool
+		want    string
+	}{
+		{address: "localhost:8080", want: "http://localhost:8080"},
+		{address: "localhost:8443", secure: true, want: "https://localhost:8443"},
+		{address: "https://metrics.internal:8443/", want: "https://metrics.internal:8443"},
+		{address: "http://localhost:8080", secure: true, want: "http://localhost:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equal(t, tt.want, ServerURL(tt.address, tt.secure))
+		})
+	}
+}
+
+func TestServerPool_Probe(t *testing.T) {
+	p, err := NewServerPool([]string{"a", "b"}, false, 0)
+	require.NoError(t, err)
+	pings := 0
+	ping := func(url string) error {
+		pings++
+		assert.Equal(t, "a", url)
+		return errors.New("connection refused")
+	}
+	// primary is active, so there is nothing to check
+	p.Probe(ping)
+	assert.Equal(t, 0, pings)
+
+	assert.NoError(t, p.Send(func(url string) error {
+		if url == "a" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}))
+	assert.Equal(t, "b", p.Active())
+	p.Probe(ping)
+	assert.Equal(t, 1, pings)
+	assert.Equal(t, "b", p.Active())
+	p.Probe(func(url string) error { return nil })
+	assert.Equal(t, "a", p.Active())
+}
+
+func TestServerPool_FanOut(t *testing.T) {
+	p, err := NewServerPool([]string{"primary", "staging"}, true, 0)
+	require.NoError(t, err)
+	var m sync.Mutex
+	got := make(map[string]int)
+	send := func(down string) error {
+		return p.Send(func(url string) error {
+			m.Lock()
+			got[url]++
+			m.Unlock()
+			if url == down {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+	}
+	assert.NoError(t, send(""))
+	// failures of mirrors do not affect the result
+	assert.NoError(t, send("staging"))
+	assert.Error(t, send("primary"))
+	assert.Equal(t, map[string]int{"primary": 3, "staging": 3}, got)
+}
+
+func TestMetricSender_Failover(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var primaryIsDown atomic.Bool
+	primaryIsDown.Store(true)
+	var primaryBatches, secondaryBatches atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if primaryIsDown.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/updates/" {
+			primaryBatches.Add(1)
+		}
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryBatches.Add(1)
+	}))
+	defer secondary.Close()
+	servers, err := NewServerPool([]string{primary.URL, secondary.URL}, false, 0)
+	require.NoError(t, err)
+	r := MetricSender{Client: resty.New(), Servers: servers}
+
+	assert.NoError(t, r.SendMetrics(batchWithDelta(1)))
+	assert.Equal(t, int64(1), secondaryBatches.Load())
+	assert.Equal(t, secondary.URL, servers.Active())
+
+	servers.Probe(r.Ping)
+	assert.Equal(t, secondary.URL, servers.Active())
+	primaryIsDown.Store(false)
+	servers.Probe(r.Ping)
+	assert.Equal(t, primary.URL, servers.Active())
+	assert.NoError(t, r.SendMetrics(batchWithDelta(2)))
+	assert.Equal(t, int64(1), primaryBatches.Load())
+	assert.Equal(t, int64(1), secondaryBatches.Load())
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const statsdMaxPacketSize = 65535
+
+// StatsdSample is one parsed line of StatsD protocol: '<name>:<value>|<type>[|@<rate>]'
+type StatsdSample struct {
+	Name  string
+	Value float64
+	Type  string
+	Rate  float64
+	// Relative is set for gauges like 'name:+5|g' which change the current value
+	Relative bool
+}
+
+// ParseStatsdLine parses counters ('c'), gauges ('g') and timers ('ms' and 'h'),
+// tags in DogStatsD format ('|#tag:value') are ignored
+func ParseStatsdLine(line string) (StatsdSample, error) {
+	sample := StatsdSample{Rate: 1}
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample, fmt.Errorf("there is no metric name in line '%s'", line)
+	}
+	sample.Name = name
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return sample, fmt.Errorf("there is no metric type in line '%s'", line)
+	}
+	sample.Type = parts[1]
+	switch sample.Type {
+	case "c", "g", "ms", "h":
+	default:
+		return sample, fmt.Errorf("unsupported metric type '%s' in line '%s'", sample.Type, line)
+	}
+	value := parts[0]
+	if sample.Type == "g" && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")) {
+		sample.Relative = true
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return sample, fmt.Errorf("wrong value '%s' in line '%s'", value, line)
+	}
+	sample.Value = v
+	for _, part := range parts[2:] {
+		if !strings.HasPrefix(part, "@") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(part[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return sample, fmt.Errorf("wrong sample rate '%s' in line '%s'", part, line)
+		}
+		sample.Rate = rate
+	}
+	return sample, nil
+}
+
+// StatsdListener receives metrics in StatsD line protocol over UDP and puts them to Buffer:
+// counters are summed till the next report, gauges keep the last value and
+// timers are summarized into gauges by the buffer.
+type StatsdListener struct {
+	Address string
+	Buffer  *MetricBuffer
+	conn    net.PacketConn
+	m       sync.Mutex
+	gauges  map[string]float64
+}
+
+func NewStatsdListener(address string, buffer *MetricBuffer) *StatsdListener {
+	return &StatsdListener{Address: address, Buffer: buffer, gauges: make(map[string]float64)}
+}
+
+// Listen binds UDP socket, after that packets are queued by the OS till Serve is called
+func (l *StatsdListener) Listen() error {
+	conn, err := net.ListenPacket("udp", l.Address)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	fmt.Printf("StatsD listener is running on %s...\n", conn.LocalAddr())
+	return nil
+}
+
+func (l *StatsdListener) LocalAddr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Serve handles packets till done is closed
+func (l *StatsdListener) Serve(done chan bool) {
+	go func() {
+		<-done
+		l.conn.Close()
+	}()
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				fmt.Println("StatsD listener has been stopped")
+				return
+			}
+			fmt.Println("StatsD read problem:", err.Error())
+			continue
+		}
+		err = l.HandlePacket(buf[:n])
+		if err != nil {
+			fmt.Println("StatsD packet has wrong lines:", err.Error())
+		}
+	}
+}
+
+// HandlePacket puts every correct line of packet to the buffer and returns errors of the others
+func (l *StatsdListener) HandlePacket(packet []byte) error {
+	var rErr error
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		sample, err := ParseStatsdLine(line)
+		if err != nil {
+			rErr = errors.Join(rErr, err)
+			continue
+		}
+		l.add(sample)
+	}
+	return rErr
+}
+
+func (l *StatsdListener) add(sample StatsdSample) {
+	switch sample.Type {
+	case "c":
+		// sampled counts are rounded once per report, so fractions of samples are not lost
+		l.Buffer.AddCount(sample.Name, sample.Value/sample.Rate)
+	case "g":
+		l.m.Lock()
+		value := sample.Value
+		if sample.Relative {
+			value += l.gauges[sample.Name]
+		}
+		l.gauges[sample.Name] = value
+		l.m.Unlock()
+		l.Buffer.Add(gauge(sample.Name, value))
+	case "ms", "h":
+		l.Buffer.AddTiming(sample.Name, sample.Value, sample.Rate)
+	}
+}
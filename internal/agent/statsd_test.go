@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    StatsdSample
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			line: "app.requests:5|c",
+			want: StatsdSample{Name: "app.requests", Value: 5, Type: "c", Rate: 1},
+		},
+		{
+			name: "counter_with_rate_and_tags",
+			line: "app.requests:1|c|@0.1|#env:prod",
+			want: StatsdSample{Name: "app.requests", Value: 1, Type: "c", Rate: 0.1},
+		},
+		{
+			name: "gauge",
+			line: "app.queue:12.5|g",
+			want: StatsdSample{Name: "app.queue", Value: 12.5, Type: "g", Rate: 1},
+		},
+		{
+			name: "relative_gauge",
+			line: "app.queue:-2|g",
+			want: StatsdSample{Name: "app.queue", Value: -2, Type: "g", Rate: 1, Relative: true},
+		},
+		{
+			name: "timer",
+			line: "app.latency:320|ms|@0.5",
+			want: StatsdSample{Name: "app.latency", Value: 320, Type: "ms", Rate: 0.5},
+		},
+		{
+			name:    "set_is_not_supported",
+			line:    "app.users:42|s",
+			wantErr: true,
+		},
+		{
+			name:    "no_type",
+			line:    "app.requests:5",
+			wantErr: true,
+		},
+		{
+			name:    "no_name",
+			line:    ":5|c",
+			wantErr: true,
+		},
+		{
+			name:    "wrong_value",
+			line:    "app.requests:five|c",
+			wantErr: true,
+		},
+		{
+			name:    "wrong_rate",
+			line:    "app.requests:5|c|@2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatsdLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatsdListener_HandlePacket(t *testing.T) {
+	buffer := NewMetricBuffer()
+	l := NewStatsdListener("", buffer)
+	err := l.HandlePacket([]byte(
+		"requests:1|c\nrequests:2|c|@0.5\nqueue:10|g\nqueue:+5|g\nbroken\n" +
+			"latency:10|ms\nlatency:20|ms\nlatency:30|ms\nlatency:40|ms|@0.5\n",
+	))
+	assert.Error(t, err)
+	got := metricsToMap(buffer.Flush())
+	assert.Equal(t, int64(5), *got["requests"].Delta)
+	assert.Equal(t, 15.0, *got["queue"].Value)
+	assert.Equal(t, 5.0, *got["latency.count"].Value)
+	assert.Equal(t, 10.0, *got["latency.min"].Value)
+	assert.Equal(t, 40.0, *got["latency.max"].Value)
+	assert.Equal(t, 25.0, *got["latency.mean"].Value)
+	assert.Equal(t, 20.0, *got["latency.p50"].Value)
+	assert.Equal(t, 40.0, *got["latency.p95"].Value)
+	assert.Equal(t, 40.0, *got["latency.p99"].Value)
+
+	// gauges keep their value between reports, so relative changes are applied to it
+	assert.NoError(t, l.HandlePacket([]byte("queue:-3|g")))
+	got = metricsToMap(buffer.Flush())
+	assert.Equal(t, 1, len(got))
+	assert.Equal(t, 12.0, *got["queue"].Value)
+
+	// sampled counts are summed before rounding: 10 samples at rate 0.3 are 33.3 events, not 10*3
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.HandlePacket([]byte("sampled:1|c|@0.3")))
+	}
+	assert.NoError(t, l.HandlePacket([]byte("sampled:2|c")))
+	got = metricsToMap(buffer.Flush())
+	assert.Equal(t, int64(35), *got["sampled"].Delta)
+
+	// names with characters escaped in keys are summed too
+	assert.NoError(t, l.HandlePacket([]byte("jobs{a=b}:1|c|@0.5\njobs{a=b}:2|c")))
+	metrics := buffer.Flush()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "jobs{a=b}", metrics[0].ID)
+	assert.Equal(t, int64(4), *metrics[0].Delta)
+}
+
+func TestStatsdListener_Serve(t *testing.T) {
+	buffer := NewMetricBuffer()
+	l := NewStatsdListener("127.0.0.1:0", buffer)
+	require.NoError(t, l.Listen())
+	done := make(chan bool)
+	stopped := make(chan struct{})
+	go func() {
+		l.Serve(done)
+		close(stopped)
+	}()
+	conn, err := net.Dial("udp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("jobs.done:3|c\njobs.duration:1.5|ms"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return buffer.Len() == 2 }, time.Second, 10*time.Millisecond)
+	close(done)
+	<-stopped
+	got := metricsToMap(buffer.Flush())
+	assert.Equal(t, int64(3), *got["jobs.done"].Delta)
+	assert.Equal(t, 1.5, *got["jobs.duration.max"].Value)
+}
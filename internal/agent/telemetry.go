@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"time"
+)
+
+// Telemetry records metrics of the agent itself into the buffer, so they are sent
+// with the regular batches. Names of all of them start with Prefix:
+// counters '<Prefix>BatchesSent', '<Prefix>BatchesFailed', '<Prefix>Retries', '<Prefix>PayloadBytes',
+// '<Prefix>DroppedMetrics' and '<Prefix>QueueDroppedBatches', gauges '<Prefix>QueueDepth',
+// '<Prefix>QueueBytes' and '<Prefix>CollectDuration' in seconds labeled by 'collector', and summary
+// of '<Prefix>RequestLatency' in seconds like timings of MetricBuffer.
+// Methods of nil Telemetry do nothing.
+type Telemetry struct {
+	Prefix       string
+	Buffer       *MetricBuffer
+	queueDropped *counterTracker
+}
+
+// CollectorLabel is a label of metrics which tells collector they are about
+const CollectorLabel = "collector"
+
+func NewTelemetry(prefix string, buffer *MetricBuffer) *Telemetry {
+	return &Telemetry{Prefix: prefix, Buffer: buffer, queueDropped: newCounterTracker()}
+}
+
+func (t *Telemetry) BatchSent() {
+	if t == nil {
+		return
+	}
+	t.Buffer.Add(counter(t.Prefix+"BatchesSent", 1))
+}
+
+func (t *Telemetry) BatchFailed() {
+	if t == nil {
+		return
+	}
+	t.Buffer.Add(counter(t.Prefix+"BatchesFailed", 1))
+}
+
+// Dropped counts metrics which are lost, because they cannot be sent or queued
+func (t *Telemetry) Dropped(metrics int) {
+	if t == nil || metrics == 0 {
+		return
+	}
+	t.Buffer.Add(counter(t.Prefix+"DroppedMetrics", int64(metrics)))
+}
+
+func (t *Telemetry) Retry() {
+	if t == nil {
+		return
+	}
+	t.Buffer.Add(counter(t.Prefix+"Retries", 1))
+}
+
+// Request records one HTTP request, bytes are counted only for successful ones
+func (t *Telemetry) Request(latency time.Duration, bytes int, success bool) {
+	if t == nil {
+		return
+	}
+	t.Buffer.AddTiming(t.Prefix+"RequestLatency", latency.Seconds(), 1)
+	if success {
+		t.Buffer.Add(counter(t.Prefix+"PayloadBytes", int64(bytes)))
+	}
+}
+
+func (t *Telemetry) Collected(collector string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+	labels := map[string]string{CollectorLabel: collector}
+	t.Buffer.Add(labeled(gauge(t.Prefix+"CollectDuration", duration.Seconds()), labels))
+}
+
+// Queue records state of the queue, it is called before every report
+func (t *Telemetry) Queue(q *DiskQueue) {
+	if t == nil || q == nil {
+		return
+	}
+	t.Buffer.Add(
+		gauge(t.Prefix+"QueueDepth", float64(q.Len())),
+		gauge(t.Prefix+"QueueBytes", float64(q.Size())),
+	)
+	delta, ok := t.queueDropped.Delta("dropped", uint64(q.Dropped()))
+	if !ok {
+		// batches dropped before the first report are counted too
+		delta = q.Dropped()
+	}
+	if delta > 0 {
+		t.Buffer.Add(counter(t.Prefix+"QueueDroppedBatches", delta))
+	}
+}
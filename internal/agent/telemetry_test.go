@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricSender_Telemetry(t *testing.T) {
+	if AgentKey == nil {
+		ParseArgsClient()
+	}
+	var status atomic.Int64
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, request *http.Request) {
+				w.WriteHeader(int(status.Load()))
+			},
+		),
+	)
+	defer server.Close()
+	q, err := NewDiskQueue(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	buffer := NewMetricBuffer()
+	r := MetricSender{
+		URL:       server.URL,
+		Client:    resty.New(),
+		Retry:     &general.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		Queue:     q,
+		Buffer:    buffer,
+		Telemetry: NewTelemetry("Agent", buffer),
+	}
+	r.Collect(context.Background(), &fakeCollector{name: "fake", metrics: []general.Metrics{gauge("A", 1)}})
+
+	// batch is retried and queued
+	status.Store(http.StatusServiceUnavailable)
+	assert.NoError(t, r.Deliver(batchWithDelta(1)))
+	// batch is sent together with the queued one
+	status.Store(http.StatusOK)
+	assert.NoError(t, r.Deliver(batchWithDelta(2)))
+	// batch is rejected
+	status.Store(http.StatusBadRequest)
+	assert.Error(t, r.Deliver(batchWithDelta(3)))
+
+	got := metricsToMap(r.PrepareBatch())
+	assert.Equal(t, 1.0, *got["A"].Value)
+	assert.Contains(t, got, "AgentCollectDuration{collector=fake}")
+	assert.Equal(t, int64(2), *got["AgentBatchesSent"].Delta)
+	assert.Equal(t, int64(2), *got["AgentBatchesFailed"].Delta)
+	assert.Equal(t, int64(1), *got["AgentRetries"].Delta)
+	assert.Equal(t, int64(1), *got["AgentDroppedMetrics"].Delta)
+	assert.Equal(t, 5.0, *got["AgentRequestLatency.count"].Value)
+	assert.Contains(t, got, "AgentRequestLatency.p99")
+	b, err := json.Marshal(batchWithDelta(1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*len(b)), *got["AgentPayloadBytes"].Delta)
+	assert.Equal(t, 0.0, *got["AgentQueueDepth"].Value)
+	assert.NotContains(t, got, "AgentQueueDroppedBatches")
+
+	// telemetry is optional
+	r.Telemetry = nil
+	status.Store(http.StatusOK)
+	assert.NoError(t, r.Deliver(batchWithDelta(4)))
+	assert.Empty(t, r.PrepareBatch())
+}
@@ -0,0 +1,82 @@
+package general
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open, request has not been sent")
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls of a dead service. It opens after Threshold failures in a row,
+// and after Cooldown lets one trial call pass: the breaker is closed if the call succeeds
+// and opened again for Cooldown if it fails.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	m         sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed, now: time.Now}
+}
+
+// Allow tells if call can be done, every allowed call has to be followed by Record or Abandon
+func (b *CircuitBreaker) Allow() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// trial call is in progress
+		return false
+	}
+	return true
+}
+
+// Record registers result of the allowed call
+func (b *CircuitBreaker) Record(success bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if success {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Abandon registers allowed call which has been interrupted without result, e.g. on shutdown,
+// it is not counted as success or failure and the trial call can be done again
+func (b *CircuitBreaker) Abandon() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.state
+}
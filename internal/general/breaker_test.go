@@ -0,0 +1,44 @@
+package general
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, b.Allow())
+		b.Record(false)
+	}
+	assert.Equal(t, BreakerClosed, b.State())
+	// success resets the counter of failures
+	assert.True(t, b.Allow())
+	b.Record(true)
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.Allow())
+		b.Record(false)
+	}
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// only one trial call is allowed after cooldown
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.False(t, b.Allow())
+	b.Record(false)
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+	b.Record(true)
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.True(t, b.Allow())
+}
@@ -1,6 +1,7 @@
 package general
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"net/http"
@@ -33,3 +34,21 @@ func (w GzipWriter) Write(b []byte) (int, error) {
 	w.WriteHeader(http.StatusOK)
 	return w.Writer.Write(b)
 }
+
+// Compress returns data compressed by gzip with the level from gzip.HuffmanOnly to gzip.BestCompression
+func Compress(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = gz.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
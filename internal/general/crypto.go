@@ -0,0 +1,146 @@
+package general
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// EncryptionScheme is a value of Content-Encryption header of encrypted requests
+const EncryptionScheme = "rsa-oaep-aes256-gcm"
+
+// ErrDecryption is returned when data is encrypted with another key or is corrupted
+var ErrDecryption = errors.New("data cannot be decrypted, probably it is encrypted with another key")
+
+// sessionKeySize is a size of AES-256 key generated for every message
+const sessionKeySize = 32
+
+// LoadPublicKey reads RSA public key from PEM file in PKIX ('PUBLIC KEY') or PKCS #1 ('RSA PUBLIC KEY') format
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("public key '%s' cannot be parsed: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key '%s' is not RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+// LoadPrivateKey reads RSA private key from PEM file in PKCS #8 ('PRIVATE KEY') or PKCS #1 ('RSA PRIVATE KEY') format
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("private key '%s' cannot be parsed: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key '%s' is not RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key cannot be read: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("file '%s' has no PEM data", path)
+	}
+	return block, nil
+}
+
+// Encrypt encrypts data of any size with hybrid scheme: data is encrypted by AES-256-GCM
+// with random session key, and the session key is encrypted by RSA-OAEP with SHA-256.
+// Result is 2 bytes of length of encrypted session key, the key, nonce and sealed data.
+func Encrypt(key *rsa.PublicKey, data []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeySize)
+	_, err := rand.Read(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 2, 2+len(encryptedKey)+len(nonce)+len(data)+gcm.Overhead())
+	binary.BigEndian.PutUint16(result, uint16(len(encryptedKey)))
+	result = append(result, encryptedKey...)
+	result = append(result, nonce...)
+	return gcm.Seal(result, nonce, data, nil), nil
+}
+
+// Decrypt decrypts data encrypted by Encrypt, ErrDecryption is returned if key does not fit
+func Decrypt(key *rsa.PrivateKey, data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, ErrDecryption
+	}
+	keySize := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if keySize != key.Size() || len(data) < keySize {
+		return nil, ErrDecryption
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, data[:keySize], nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	data = data[keySize:]
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrDecryption
+	}
+	result, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return result, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
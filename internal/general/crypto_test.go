@@ -0,0 +1,115 @@
+package general
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeys writes PEM files of a new key pair, PKCS #1 format is used if pkcs1 is set
+func writeKeys(t *testing.T, pkcs1 bool) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	publicBlock := &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}
+	if !pkcs1 {
+		b, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		privateBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: b}
+		b, err = x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		publicBlock = &pem.Block{Type: "PUBLIC KEY", Bytes: b}
+	}
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(privateBlock), 0o600))
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(publicBlock), 0o600))
+	return publicPath, privatePath
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	tests := []struct {
+		name  string
+		pkcs1 bool
+		size  int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 100},
+		{name: "larger_than_rsa_block", pkcs1: true, size: 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicPath, privatePath := writeKeys(t, tt.pkcs1)
+			public, err := LoadPublicKey(publicPath)
+			require.NoError(t, err)
+			private, err := LoadPrivateKey(privatePath)
+			require.NoError(t, err)
+			data := bytes.Repeat([]byte("a"), tt.size)
+			encrypted, err := Encrypt(public, data)
+			require.NoError(t, err)
+			if tt.size > 0 {
+				assert.False(t, bytes.Contains(encrypted, data))
+			}
+			decrypted, err := Decrypt(private, encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, len(data), len(decrypted))
+			assert.True(t, bytes.Equal(data, decrypted))
+		})
+	}
+}
+
+func TestDecrypt_WrongData(t *testing.T) {
+	publicPath, _ := writeKeys(t, false)
+	_, otherPrivatePath := writeKeys(t, false)
+	public, err := LoadPublicKey(publicPath)
+	require.NoError(t, err)
+	otherPrivate, err := LoadPrivateKey(otherPrivatePath)
+	require.NoError(t, err)
+	encrypted, err := Encrypt(public, []byte(`[{"id":"A","type":"gauge","value":1}]`))
+	require.NoError(t, err)
+	corrupted := append([]byte(nil), encrypted...)
+	corrupted[len(corrupted)-1] ^= 1
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "another_key", data: encrypted},
+		{name: "plain_text", data: []byte(`[{"id":"A","type":"gauge","value":1}]`)},
+		{name: "too_short", data: []byte{1}},
+		{name: "truncated", data: encrypted[:300]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt(otherPrivate, tt.data)
+			assert.ErrorIs(t, err, ErrDecryption)
+		})
+	}
+	_, privatePath := writeKeys(t, false)
+	private, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+	_, err = Decrypt(private, corrupted)
+	assert.ErrorIs(t, err, ErrDecryption)
+}
+
+func TestLoadKeys_Wrong(t *testing.T) {
+	publicPath, privatePath := writeKeys(t, false)
+	_, err := LoadPublicKey(privatePath)
+	assert.Error(t, err)
+	_, err = LoadPrivateKey(publicPath)
+	assert.Error(t, err)
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "absent.pem"))
+	assert.Error(t, err)
+	notPEM := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("key"), 0o600))
+	_, err = LoadPrivateKey(notPEM)
+	assert.Error(t, err)
+}
@@ -1,30 +1,167 @@
 package general
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
-func RetryCode(f func() error, errorType syscall.Errno) error {
-	sleepTime := time.Second
-	countOfRepetition := 3
-	for i := 0; i >= 0; i++ {
-		err := f()
-		if err != nil {
-			fmt.Println("Error:", err.Error())
-			isErrorType := errors.Is(err, errorType)
-			fmt.Println("isErrorType:", isErrorType)
-			if isErrorType && i < countOfRepetition {
-				time.Sleep(sleepTime)
-				fmt.Println("Repeating... SleepTime:", sleepTime)
-				sleepTime += 2 * time.Second
-				continue
+// HTTPStatusError is returned when server has answered with unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	// RetryAfter is a delay requested by server in Retry-After header, it is 0 if there is no header
+	RetryAfter time.Duration
+	Body       string
+}
+
+func NewHTTPStatusError(statusCode int, header http.Header, body string) *HTTPStatusError {
+	return &HTTPStatusError{
+		StatusCode: statusCode,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+		Body:       strings.TrimSpace(body),
+	}
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("status code - %v, response - '%s'", e.StatusCode, e.Body)
+}
+
+// parseRetryAfter parses delay in seconds or date when request can be repeated
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// IsRetryable tells if operation failed with err can succeed if it is repeated:
+// connection is refused or reset, timeout is exceeded, or server is overloaded or unavailable
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	for _, errno := range []syscall.Errno{
+		syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryPolicy repeats operation while it fails with retryable errors.
+// Delay before attempt n+1 is BaseDelay*2^(n-1) limited by MaxDelay, and up to Jitter part
+// of it is random, so clients do not repeat requests at the same moment.
+// If server has asked to wait longer than MaxDelay by Retry-After, the error is returned at once.
+type RetryPolicy struct {
+	// MaxAttempts is a limit of calls including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is a part of delay from 0 to 1 which is random
+	Jitter float64
+	// Retryable classifies errors, IsRetryable is used if it is nil
+	Retryable func(err error) bool
+	// Breaker rejects calls without trying if it is open
+	Breaker *CircuitBreaker
+	// OnRetry is called before waiting for the next attempt if it is set
+	OnRetry func(attempt int, err error)
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}
+}
+
+// Do calls f till it succeeds, fails with not retryable error, attempts are over or ctx is done
+func (p RetryPolicy) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	for attempt := 1; ; attempt++ {
+		if p.Breaker != nil && !p.Breaker.Allow() {
+			return ErrCircuitOpen
+		}
+		err := f(ctx)
+		if err != nil && ctx.Err() != nil {
+			// call is interrupted by the caller, e.g. on reload or shutdown, so it tells
+			// nothing about the server
+			if p.Breaker != nil {
+				p.Breaker.Abandon()
 			}
 			return err
 		}
-		return nil
+		isRetryable := err != nil && retryable(err)
+		if p.Breaker != nil {
+			// not retryable errors are answers of the alive server, e.g. bad request
+			p.Breaker.Record(!isRetryable)
+		}
+		if !isRetryable || attempt >= p.MaxAttempts {
+			return err
+		}
+		delay := p.Delay(attempt)
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			if statusErr.RetryAfter > p.MaxDelay {
+				return err
+			}
+			delay = statusErr.RetryAfter
+		}
+		fmt.Printf("Attempt %d has failed: %s, repeating in %v...\n", attempt, err.Error(), delay)
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+// Delay returns time to wait after attempt, attempts are counted from 1
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
 	}
-	return nil
+	return delay
 }
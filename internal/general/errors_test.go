@@ -0,0 +1,194 @@
+package general
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{
+			name: "connection refused",
+			err:  &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want: true,
+		},
+		{name: "connection reset", err: fmt.Errorf("post: %w", syscall.ECONNRESET), want: true},
+		{name: "closed connection", err: fmt.Errorf("post: %w", io.EOF), want: true},
+		{name: "timeout", err: context.DeadlineExceeded, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "unavailable", err: &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "bad gateway", err: &HTTPStatusError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "gateway timeout", err: &HTTPStatusError{StatusCode: http.StatusGatewayTimeout}, want: true},
+		{name: "too many requests", err: &HTTPStatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "internal error", err: &HTTPStatusError{StatusCode: http.StatusInternalServerError}, want: false},
+		{name: "bad request", err: &HTTPStatusError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "circuit is open", err: ErrCircuitOpen, want: false},
+		{name: "other", err: errors.New("permission denied"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestNewHTTPStatusError(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "3")
+	assert.Equal(t, 3*time.Second, NewHTTPStatusError(http.StatusTooManyRequests, header, "").RetryAfter)
+	header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	retryAfter := NewHTTPStatusError(http.StatusTooManyRequests, header, "").RetryAfter
+	assert.True(t, retryAfter > 50*time.Second && retryAfter <= time.Minute, retryAfter)
+	header.Set("Retry-After", "soon")
+	assert.Equal(t, time.Duration(0), NewHTTPStatusError(http.StatusTooManyRequests, header, "").RetryAfter)
+	assert.Equal(
+		t, "status code - 400, response - 'wrong type'",
+		NewHTTPStatusError(http.StatusBadRequest, http.Header{}, "wrong type\n").Error(),
+	)
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Jitter: 0.5}
+	unavailable := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+
+	calls := 0
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return unavailable
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return unavailable
+	})
+	assert.ErrorIs(t, err, unavailable)
+	assert.Equal(t, 3, calls)
+
+	// not retryable error is returned at once
+	calls = 0
+	err = p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return &HTTPStatusError{StatusCode: http.StatusBadRequest}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// server asks to wait longer than policy allows
+	calls = 0
+	err = p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return &HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// Retry-After is respected
+	calls = 0
+	start := time.Now()
+	err = p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return &HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 8 * time.Millisecond}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 8*time.Millisecond)
+
+	// waiting is stopped when context is done
+	p.BaseDelay, p.MaxDelay = time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = p.Do(ctx, func(ctx context.Context) error {
+		return unavailable
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, unavailable)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	assert.Equal(t, time.Second, p.Delay(1))
+	assert.Equal(t, 2*time.Second, p.Delay(2))
+	assert.Equal(t, 4*time.Second, p.Delay(3))
+	assert.Equal(t, 5*time.Second, p.Delay(4))
+	assert.Equal(t, 5*time.Second, p.Delay(100))
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Delay(2)
+		assert.True(t, d > time.Second && d <= 2*time.Second, d)
+	}
+}
+
+func TestRetryPolicy_DoWithBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, time.Minute)
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Breaker: b}
+	calls := 0
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return syscall.ECONNREFUSED
+	})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+	err = p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryPolicy_DoCanceled(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Minute)
+	b.now = func() time.Time { return now }
+	p := RetryPolicy{MaxAttempts: 1, Breaker: b}
+	refused := func(ctx context.Context) error {
+		return syscall.ECONNREFUSED
+	}
+	assert.ErrorIs(t, p.Do(context.Background(), refused), syscall.ECONNREFUSED)
+	assert.Equal(t, BreakerOpen, b.State())
+
+	// trial call interrupted on shutdown does not close the breaker of the failing server
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := p.Do(ctx, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, BreakerOpen, b.State())
+
+	// the next trial call is allowed and its result is recorded
+	assert.ErrorIs(t, p.Do(context.Background(), refused), syscall.ECONNREFUSED)
+	assert.Equal(t, BreakerOpen, b.State())
+	now = now.Add(time.Minute)
+	assert.NoError(t, p.Do(context.Background(), func(ctx context.Context) error { return nil }))
+	assert.Equal(t, BreakerClosed, b.State())
+}
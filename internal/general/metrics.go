@@ -2,13 +2,49 @@ package general
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
+// Metrics is a value of metric. Labels tell apart metrics with the same ID,
+// e.g. reported by different hosts, they are stored separately.
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Delta  *int64            `json:"delta,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// keyEscaper escapes characters which separate ID and labels in keys, so different metrics
+// cannot have the same key
+var keyEscaper = strings.NewReplacer(`\`, `\\`, "{", `\{`, "}", `\}`, ",", `\,`, "=", `\=`)
+
+// Key returns ID of metric if it has no labels, otherwise ID with labels sorted by name
+// like 'Alloc{dc=eu,host=web1}'. Characters '{', '}', ',', '=' and '\' of ID and labels are
+// escaped by '\'. Metrics are stored by their keys.
+func (m *Metrics) Key() string {
+	if len(m.Labels) == 0 {
+		return keyEscaper.Replace(m.ID)
+	}
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(keyEscaper.Replace(m.ID))
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(keyEscaper.Replace(name))
+		b.WriteByte('=')
+		b.WriteString(keyEscaper.Replace(m.Labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
 }
 
 func (m *Metrics) String() string {
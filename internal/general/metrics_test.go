@@ -261,3 +261,55 @@ func TestNewGauge(t *testing.T) {
 		})
 	}
 }
+
+func TestMetrics_Key(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metrics
+		want   string
+	}{
+		{
+			name:   "no_labels",
+			metric: Metrics{ID: "Alloc"},
+			want:   "Alloc",
+		},
+		{
+			name:   "empty_labels",
+			metric: Metrics{ID: "Alloc", Labels: map[string]string{}},
+			want:   "Alloc",
+		},
+		{
+			name:   "sorted_labels",
+			metric: Metrics{ID: "Alloc", Labels: map[string]string{"host": "web1", "dc": "eu"}},
+			want:   "Alloc{dc=eu,host=web1}",
+		},
+		{
+			name:   "escaped",
+			metric: Metrics{ID: `Disk{used}`, Labels: map[string]string{"a": `x,b=y`, `c\`: "z"}},
+			want:   `Disk\{used\}{a=x\,b\=y,c\\=z}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.metric.Key())
+		})
+	}
+}
+
+func TestMetrics_KeyCollisions(t *testing.T) {
+	metrics := []Metrics{
+		{ID: "Alloc", Labels: map[string]string{"a": "x,b=y"}},
+		{ID: "Alloc", Labels: map[string]string{"a": "x", "b": "y"}},
+		{ID: "Alloc{a=x}"},
+		{ID: "Alloc", Labels: map[string]string{"a": "x"}},
+		{ID: "Alloc", Labels: map[string]string{"a=x": ""}},
+		{ID: "Alloc", Labels: map[string]string{"a": "=x"}},
+	}
+	keys := make(map[string]int)
+	for i, m := range metrics {
+		if j, ok := keys[m.Key()]; ok {
+			t.Errorf("metrics %d and %d have the same key '%s'", j, i, m.Key())
+		}
+		keys[m.Key()] = i
+	}
+}
@@ -0,0 +1,146 @@
+package general
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+// newTestCA creates self-signed CA and writes its certificate to dir
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	path := filepath.Join(dir, name+".pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+// issue writes certificate and key signed by CA, server certificates are valid for dnsNames and 127.0.0.1
+func (ca *testCA) issue(t *testing.T, dir string, name string, server bool, dnsNames ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = dnsNames
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certPath, keyPath
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", true, "metrics.internal")
+	clientCert, clientKey := ca.issue(t, dir, "client", false)
+	otherClientCert, otherClientKey := otherCA.issue(t, dir, "other-client", false)
+
+	tests := []struct {
+		name       string
+		clientCA   string
+		caFile     string
+		certFile   string
+		keyFile    string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "tls", caFile: ca.path},
+		{name: "server_name_override", caFile: ca.path, serverName: "metrics.internal"},
+		{name: "wrong_server_name", caFile: ca.path, serverName: "other.internal", wantErr: true},
+		{name: "unknown_server_ca", caFile: otherCA.path, wantErr: true},
+		{name: "mtls", clientCA: ca.path, caFile: ca.path, certFile: clientCert, keyFile: clientKey},
+		{name: "mtls_without_client_cert", clientCA: ca.path, caFile: ca.path, wantErr: true},
+		{
+			name: "mtls_unknown_client_ca", clientCA: ca.path, caFile: ca.path,
+			certFile: otherClientCert, keyFile: otherClientKey, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverCfg, err := ServerTLSConfig(serverCert, serverKey, tt.clientCA)
+			require.NoError(t, err)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			server.TLS = serverCfg
+			server.StartTLS()
+			defer server.Close()
+			clientCfg, err := ClientTLSConfig(tt.caFile, tt.certFile, tt.keyFile, tt.serverName)
+			require.NoError(t, err)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+			resp, err := client.Get(server.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestTLSConfig_WrongFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	cert, key := ca.issue(t, dir, "client", false)
+	_, err := ClientTLSConfig(ca.path, cert, "", "")
+	assert.Error(t, err)
+	_, err = ClientTLSConfig(key, "", "", "")
+	assert.Error(t, err)
+	_, err = ClientTLSConfig(filepath.Join(dir, "absent.pem"), "", "", "")
+	assert.Error(t, err)
+	_, err = ServerTLSConfig(cert, ca.path, "")
+	assert.Error(t, err)
+	_, err = ServerTLSConfig(cert, key, filepath.Join(dir, "absent.pem"))
+	assert.Error(t, err)
+}
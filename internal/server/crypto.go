@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/akashipov/MetricCollector/internal/general"
+)
+
+// PrivateKey decrypts request bodies, requests are not decrypted if it is nil
+var PrivateKey *rsa.PrivateKey
+
+// LoadCryptoKey reads private key from CryptoKey file if it is set
+func LoadCryptoKey() error {
+	PrivateKey = nil
+	if CryptoKey == nil || *CryptoKey == "" {
+		return nil
+	}
+	key, err := general.LoadPrivateKey(*CryptoKey)
+	if err != nil {
+		return err
+	}
+	PrivateKey = key
+	fmt.Println("Requests are decrypted with key:", *CryptoKey)
+	return nil
+}
+
+// DecryptHandle decrypts bodies of requests encrypted by agent with public key of the server.
+// If the server has a private key, requests with plain bodies are rejected, so metrics are
+// never accepted over plain HTTP unencrypted. Requests without body are passed as is.
+func DecryptHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := r.Header.Get("Content-Encryption")
+		if PrivateKey == nil {
+			if scheme != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Request is encrypted, but server has no key to decrypt it"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		_, err := buf.ReadFrom(r.Body)
+		r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Cannot read body bytes"))
+			return
+		}
+		if buf.Len() == 0 {
+			r.Body = io.NopCloser(&buf)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if scheme != general.EncryptionScheme {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Request should be encrypted with '%s', got '%s'", general.EncryptionScheme, scheme)))
+			return
+		}
+		data, err := general.Decrypt(PrivateKey, buf.Bytes())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			if errors.Is(err, general.ErrDecryption) {
+				w.Write([]byte("Request cannot be decrypted, check that agent uses public key of this server"))
+				return
+			}
+			w.Write([]byte(err.Error()))
+			return
+		}
+		r.Header.Del("Content-Encryption")
+		r.ContentLength = int64(len(data))
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		next.ServeHTTP(w, r)
+	})
+}
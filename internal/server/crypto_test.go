@@ -0,0 +1,103 @@
+package server
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akashipov/MetricCollector/internal/agent"
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// writePrivateKey writes a new PEM private key and returns its path and public key
+func writePrivateKey(t *testing.T) (string, *rsa.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "private.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0o600))
+	return path, &key.PublicKey
+}
+
+func TestDecryptHandle(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	server := httptest.NewServer(ServerRouter(&s))
+	defer server.Close()
+	privatePath, publicKey := writePrivateKey(t)
+	_, otherPublicKey := writePrivateKey(t)
+	savedCryptoKey := CryptoKey
+	defer func() {
+		CryptoKey = savedCryptoKey
+		key := ""
+		ServerKey = &key
+		require.NoError(t, LoadCryptoKey())
+	}()
+	tests := []struct {
+		name       string
+		serverKey  string
+		publicKey  *rsa.PublicKey
+		hashKey    string
+		wantStatus int
+	}{
+		{name: "encrypted", serverKey: privatePath, publicKey: publicKey},
+		{name: "encrypted_and_signed", serverKey: privatePath, publicKey: publicKey, hashKey: "secret"},
+		{name: "plain", serverKey: "", publicKey: nil},
+		{name: "another_key", serverKey: privatePath, publicKey: otherPublicKey, wantStatus: http.StatusBadRequest},
+		{name: "not_encrypted", serverKey: privatePath, publicKey: nil, wantStatus: http.StatusBadRequest},
+		{name: "server_without_key", serverKey: "", publicKey: publicKey, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CryptoKey = &tt.serverKey
+			require.NoError(t, LoadCryptoKey())
+			hashKey := tt.hashKey
+			ServerKey = &hashKey
+			sender := agent.MetricSender{
+				URL:       server.URL,
+				Client:    resty.New(),
+				Key:       hashKey,
+				GzipLevel: gzip.BestSpeed,
+				PublicKey: tt.publicKey,
+			}
+			delta := int64(2)
+			err := sender.SendMetrics([]general.Metrics{{ID: "Encrypted", MType: agent.COUNTER, Delta: &delta}})
+			if tt.wantStatus != 0 {
+				var statusErr *general.HTTPStatusError
+				require.True(t, errors.As(err, &statusErr))
+				assert.Equal(t, tt.wantStatus, statusErr.StatusCode)
+				assert.Nil(t, OurStorage.Get("Encrypted", nil))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), *OurStorage.Get("Encrypted", nil).Delta)
+			if tt.hashKey == "" {
+				// requests without body are not encrypted
+				resp, err := resty.New().R().Get(server.URL + "/value/counter/Encrypted")
+				require.NoError(t, err)
+				assert.Equal(t, "2", string(resp.Body()))
+			}
+			assert.NoError(t, OurStorage.Clean())
+		})
+	}
+}
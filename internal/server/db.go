@@ -1,40 +1,105 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
-	"syscall"
 
 	"github.com/akashipov/MetricCollector/internal/general"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var DB *sql.DB
 var OurStorage Storage
 
-func InitDB() error {
-	var err error
-	if (PsqlInfo != nil) && (*PsqlInfo != "") {
-		DB, err = sql.Open("postgres", *PsqlInfo)
+// DBRetry is a policy of repeating of database calls
+var DBRetry = func() general.RetryPolicy {
+	p := general.DefaultRetryPolicy()
+	p.Retryable = IsRetryableDBError
+	return p
+}()
+
+// IsRetryableDBError tells if database call can succeed if it is repeated: connection is lost,
+// transaction is conflicted with another one, or database is starting or overloaded
+func IsRetryableDBError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "40", "53":
+			return true
+		}
+		return pqErr.Code == "57P03"
+	}
+	return general.IsRetryable(err)
+}
+
+// errCommitUnknown marks commit which has failed without telling if the transaction is applied
+var errCommitUnknown = errors.New("transaction may have been applied")
+
+// InTx runs f in a transaction which is committed if f succeeds. The whole transaction is
+// repeated by DBRetry: after a conflict or a lost connection Postgres has already aborted it,
+// so it is rolled back and f is called again with a new one. Commit which has lost connection
+// is not repeated, because the transaction may have been applied and counters would be doubled.
+func InTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	policy := DBRetry
+	policy.Retryable = func(err error) bool {
+		return !errors.Is(err, errCommitUnknown) && IsRetryableDBError(err)
+	}
+	return policy.Do(ctx, func(ctx context.Context) error {
+		tx, err := DB.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
-		f := func() error {
-			_, err = DB.Exec(
-				"CREATE TABLE IF NOT EXISTS metrics (" +
-					"id VARCHAR (50) PRIMARY KEY NOT NULL," +
-					"mtype VARCHAR (50) NOT NULL," +
-					"value double precision," +
-					"delta bigint" +
-					")",
-			)
+		err = f(tx)
+		if err != nil {
+			tx.Rollback()
 			return err
 		}
-		err = general.RetryCode(f, syscall.ECONNREFUSED)
+		err = tx.Commit()
+		var pqErr *pq.Error
+		if err != nil && !(errors.As(err, &pqErr) && pqErr.Code.Class() == "40") {
+			return fmt.Errorf("%w: %w", errCommitUnknown, err)
+		}
+		return err
+	})
+}
+
+// schema creates metrics table and migrates tables of previous versions, which had no labels:
+// id keeps key of metric with labels, name keeps ID of metric, labels are kept as JSON object
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS metrics (" +
+		"id VARCHAR (1024) PRIMARY KEY NOT NULL," +
+		"mtype VARCHAR (50) NOT NULL," +
+		"value double precision," +
+		"delta bigint," +
+		"name VARCHAR (255)," +
+		"labels TEXT" +
+		")",
+	"ALTER TABLE metrics ALTER COLUMN id TYPE VARCHAR (1024)",
+	"ALTER TABLE metrics ADD COLUMN IF NOT EXISTS name VARCHAR (255)",
+	"ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels TEXT",
+	"UPDATE metrics SET name = id WHERE name IS NULL",
+}
+
+func InitDB() error {
+	var err error
+	if (PsqlInfo != nil) && (*PsqlInfo != "") {
+		DB, err = sql.Open("postgres", *PsqlInfo)
 		if err != nil {
 			return err
 		}
+		for _, query := range schema {
+			f := func(ctx context.Context) error {
+				_, err := DB.ExecContext(ctx, query)
+				return err
+			}
+			err = DBRetry.Do(context.Background(), f)
+			if err != nil {
+				return err
+			}
+		}
 		fmt.Println("Successfully connected to the db")
 	}
 	OurStorage = NewStorage(nil)
@@ -42,10 +107,15 @@ func InitDB() error {
 }
 
 func TestConnectionPostgres(w http.ResponseWriter, request *http.Request) {
-	f := func() error {
-		return DB.Ping()
+	if DB == nil {
+		// metrics are kept in memory or in file, so there is nothing to check
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	f := func(ctx context.Context) error {
+		return DB.PingContext(ctx)
 	}
-	err := general.RetryCode(f, syscall.ECONNREFUSED)
+	err := DBRetry.Do(request.Context(), f)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
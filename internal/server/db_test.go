@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDB is a database driver which fails calls with errors from its lists one by one
+type fakeDB struct {
+	execErrs   []error
+	commitErrs []error
+	begins     int
+	execs      int
+	commits    int
+	rollbacks  int
+}
+
+func (d *fakeDB) Open(name string) (driver.Conn, error) {
+	return &fakeConn{db: d}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.begins++
+	return &fakeTx{db: c.db}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.execs++
+	return driver.RowsAffected(1), next(&c.db.execErrs)
+}
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (t *fakeTx) Commit() error {
+	t.db.commits++
+	return next(&t.db.commitErrs)
+}
+
+func (t *fakeTx) Rollback() error {
+	t.db.rollbacks++
+	return nil
+}
+
+func next(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+var fakeDrivers int
+
+// useFakeDB replaces DB with the fake one till the end of the test
+func useFakeDB(t *testing.T, d *fakeDB) {
+	fakeDrivers++
+	name := fmt.Sprintf("fake%d", fakeDrivers)
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	assert.NoError(t, err)
+	oldDB, oldRetry := DB, DBRetry
+	DB = db
+	DBRetry.BaseDelay = time.Millisecond
+	DBRetry.MaxDelay = time.Millisecond
+	t.Cleanup(func() {
+		db.Close()
+		DB, DBRetry = oldDB, oldRetry
+	})
+}
+
+func TestInTx(t *testing.T) {
+	conflict := &pq.Error{Code: "40001"}
+	lost := &pq.Error{Code: "08006"}
+	tests := []struct {
+		name      string
+		db        fakeDB
+		fErr      error
+		wantErr   error
+		calls     int
+		commits   int
+		rollbacks int
+	}{
+		{
+			name:    "ok",
+			calls:   1,
+			commits: 1,
+		},
+		{
+			name:      "statement has lost connection",
+			db:        fakeDB{execErrs: []error{lost}},
+			calls:     2,
+			commits:   1,
+			rollbacks: 1,
+		},
+		{
+			name:    "commit is conflicted",
+			db:      fakeDB{commitErrs: []error{conflict}},
+			calls:   2,
+			commits: 2,
+		},
+		{
+			name:    "commit has lost connection",
+			db:      fakeDB{commitErrs: []error{lost}},
+			wantErr: errCommitUnknown,
+			calls:   1,
+			commits: 1,
+		},
+		{
+			name:      "not retryable error",
+			fErr:      errStorage,
+			wantErr:   errStorage,
+			calls:     1,
+			rollbacks: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.db
+			useFakeDB(t, &d)
+			calls := 0
+			err := InTx(context.Background(), func(tx *sql.Tx) error {
+				calls++
+				if tt.fErr != nil {
+					return tt.fErr
+				}
+				_, err := tx.ExecContext(context.Background(), "INSERT")
+				return err
+			})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.calls, calls)
+			assert.Equal(t, tt.calls, d.begins)
+			assert.Equal(t, tt.commits, d.commits)
+			assert.Equal(t, tt.rollbacks, d.rollbacks)
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -13,7 +15,13 @@ var PTSave *int
 var FSPath *string
 var StartLoadMetric *bool
 var ServerKey *string
+var CryptoKey *string
+var TLSCert *string
+var TLSKey *string
+var TLSClientCA *string
 var PsqlInfo *string
+var TrustedSubnet *string
+var ConfigPath *string
 
 type ServerEnvConfig struct {
 	Address            string  `env:"ADDRESS"`
@@ -22,6 +30,18 @@ type ServerEnvConfig struct {
 	StartLoadMetric    *bool   `env:"RESTORE"`
 	ConnectionDBString *string `env:"DATABASE_DSN"`
 	KeyForHash         *string `env:"KEY"`
+	CryptoKey          *string `env:"CRYPTO_KEY"`
+	TLSCert            *string `env:"TLS_CERT"`
+	TLSKey             *string `env:"TLS_KEY"`
+	TLSClientCA        *string `env:"TLS_CLIENT_CA"`
+	TrustedSubnet      *string `env:"TRUSTED_SUBNET"`
+	ConfigPath         *string `env:"CONFIG"`
+}
+
+// ServerFileConfig is a content of JSON config file, only trusted_subnet is taken from it,
+// other fields are ignored
+type ServerFileConfig struct {
+	TrustedSubnet *string `json:"trusted_subnet"`
 }
 
 func ParseArgsServer() {
@@ -40,7 +60,28 @@ func ParseArgsServer() {
 	)
 	StartLoadMetric = flag.Bool("r", true, "Either load last metric checkpoint")
 	ServerKey = flag.String("k", "", "Key to create hash and check sign")
+	CryptoKey = flag.String("crypto-key", "", "Path to PEM file with private key to decrypt requests")
+	TLSCert = flag.String("tls-cert", "", "Path to PEM certificate of the server, HTTPS is served if it is set")
+	TLSKey = flag.String("tls-key", "", "Path to PEM private key of the server certificate")
+	TLSClientCA = flag.String("tls-client-ca", "", "Path to PEM CA bundle to verify client certificates (mutual TLS)")
+	TrustedSubnet = flag.String(
+		"t", "", "CIDR of agents whose X-Real-IP is accepted by /update, /updates and /value, empty value allows all, "+
+			"it overrides TRUSTED_SUBNET which overrides trusted_subnet of config file",
+	)
+	ConfigPath = flag.String("c", "", "Path to JSON config file with trusted_subnet")
 	flag.Parse()
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	path := *ConfigPath
+	if !explicit["c"] && cfg.ConfigPath != nil {
+		path = *cfg.ConfigPath
+	}
+	file, err := loadServerFileConfig(path)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if cfg.Address != "" {
 		HPServer = &cfg.Address
 	}
@@ -56,6 +97,20 @@ func ParseArgsServer() {
 	if cfg.KeyForHash != nil {
 		ServerKey = cfg.KeyForHash
 	}
+	if cfg.CryptoKey != nil {
+		CryptoKey = cfg.CryptoKey
+	}
+	if cfg.TLSCert != nil {
+		TLSCert = cfg.TLSCert
+	}
+	if cfg.TLSKey != nil {
+		TLSKey = cfg.TLSKey
+	}
+	if cfg.TLSClientCA != nil {
+		TLSClientCA = cfg.TLSClientCA
+	}
+	subnet := resolveTrustedSubnet(*TrustedSubnet, explicit["t"], cfg.TrustedSubnet, file)
+	TrustedSubnet = &subnet
 	if cfg.ConnectionDBString != nil {
 		PsqlInfo = cfg.ConnectionDBString
 	}
@@ -63,4 +118,36 @@ func ParseArgsServer() {
 	fmt.Println("StartLoadMetric:", *StartLoadMetric)
 	fmt.Println("Path for metrics file:", *FSPath)
 	fmt.Println("Interval to save metrics:", *PTSave)
+	fmt.Println("Trusted subnet:", *TrustedSubnet)
+}
+
+// loadServerFileConfig reads JSON config file, nothing is read if path is empty
+func loadServerFileConfig(path string) (ServerFileConfig, error) {
+	var file ServerFileConfig
+	if path == "" {
+		return file, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("config file cannot be read: %w", err)
+	}
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return file, fmt.Errorf("config file '%s' cannot be parsed: %w", path, err)
+	}
+	return file, nil
+}
+
+// resolveTrustedSubnet returns trusted subnet passed in command line, otherwise the one
+// from environment, otherwise the one from config file, otherwise the default value of flag
+func resolveTrustedSubnet(flagValue string, explicit bool, env *string, file ServerFileConfig) string {
+	switch {
+	case explicit:
+		return flagValue
+	case env != nil:
+		return *env
+	case file.TrustedSubnet != nil:
+		return *file.TrustedSubnet
+	}
+	return flagValue
 }
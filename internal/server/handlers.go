@@ -13,7 +13,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"syscall"
 
 	"github.com/akashipov/MetricCollector/internal/agent"
 	"github.com/akashipov/MetricCollector/internal/general"
@@ -53,7 +52,7 @@ func ServerRouter(s *zap.SugaredLogger) http.Handler {
 			r.Post("/", logger.WithLogging(http.HandlerFunc(GetMetricShortForm), s))
 		},
 	)
-	return HashHandle(GzipHandle(r))
+	return TrustedSubnetHandle(HashHandle(DecryptHandle(GzipHandle(r))))
 }
 
 func Update(w http.ResponseWriter, request *http.Request) {
@@ -68,15 +67,44 @@ func Update(w http.ResponseWriter, request *http.Request) {
 	if m == nil {
 		return
 	}
+	m.Labels = queryLabels(request)
 	fmt.Println("12431231243", m)
-	SaveMetric(w, m, request, nil)
+	err = SaveMetric(w, m, request, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, wErr := w.Write([]byte(err.Error()))
+		if wErr != nil {
+			fmt.Println(errors.Join(err, wErr).Error())
+		}
+	}
+}
+
+// labelParam is a prefix of URL query parameters which are labels of metric
+const labelParam = "label."
+
+// queryLabels returns labels of metric passed in URL query like '?label.host=web1&label.dc=eu',
+// parameters without 'label.' prefix are ignored, the first value is used if label is passed
+// several times
+func queryLabels(request *http.Request) map[string]string {
+	var labels map[string]string
+	for param, values := range request.URL.Query() {
+		name, ok := strings.CutPrefix(param, labelParam)
+		if !ok || name == "" {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[name] = values[0]
+	}
+	return labels
 }
 
 func SaveMetric(
 	w http.ResponseWriter, metric *general.Metrics, request *http.Request,
 	tx *sql.Tx,
 ) error {
-	val := OurStorage.Get(metric.ID, request)
+	val := OurStorage.Get(metric.Key(), request)
 	if val != nil {
 		switch metric.MType {
 		case agent.COUNTER:
@@ -95,12 +123,18 @@ func SaveMetric(
 	} else {
 		val = metric
 	}
-	OurStorage.Record(val, request, tx)
+	err := OurStorage.Record(val, request, tx)
+	if err != nil {
+		return err
+	}
 	metric.Delta = val.Delta
 	metric.Value = val.Value
 	return nil
 }
 
+// errStorage marks failure of storage, response is not written for it by ProcessMetric
+var errStorage = errors.New("storage error")
+
 func ProcessMetric(
 	w http.ResponseWriter, request *http.Request, metric *general.Metrics,
 	tx *sql.Tx,
@@ -141,11 +175,10 @@ func ProcessMetric(
 		w.WriteHeader(http.StatusBadRequest)
 		return nil
 	}
+	m.Labels = metric.Labels
 	err = SaveMetric(w, m, request, tx)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, wErr := w.Write([]byte(err.Error()))
-		return errors.Join(err, wErr)
+		return fmt.Errorf("%w: %w", errStorage, err)
 	}
 	return nil
 }
@@ -154,7 +187,7 @@ func groupByMetrics(metrics []general.Metrics) []general.Metrics {
 	newMetrics := make(map[string]general.Metrics, 0)
 	fmt.Println("Metrics to group by:")
 	for _, v := range metrics {
-		g, ok := newMetrics[v.ID]
+		g, ok := newMetrics[v.Key()]
 		if ok && (g.MType == v.MType) {
 			switch g.MType {
 			case agent.GAUGE:
@@ -163,7 +196,7 @@ func groupByMetrics(metrics []general.Metrics) []general.Metrics {
 				*(g.Delta) += *v.Delta
 			}
 		} else {
-			newMetrics[v.ID] = v
+			newMetrics[v.Key()] = v
 		}
 	}
 	fmt.Println("Metrics grouped by:")
@@ -177,58 +210,37 @@ func groupByMetrics(metrics []general.Metrics) []general.Metrics {
 
 func SaveMetrics(w http.ResponseWriter, request *http.Request, metrics []general.Metrics) {
 	results := make([]general.Metrics, 0)
-	var tx *sql.Tx
-	var err error
-	if !((PsqlInfo == nil) || (*PsqlInfo == "")) {
-		f := func() error {
-			tx, err = DB.Begin()
-			return err
-		}
-		err = general.RetryCode(f, syscall.ECONNREFUSED)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_, wErr := w.Write([]byte(err.Error()))
-			if wErr != nil {
-				fmt.Println(errors.Join(err, wErr).Error())
-			}
-			return
-		}
-	}
 	metrics = groupByMetrics(metrics)
-	for _, metric := range metrics {
-		err := ProcessMetric(w, request, &metric, tx)
-		if err != nil {
-			if !((PsqlInfo == nil) || (*PsqlInfo == "")) {
-				err = tx.Rollback()
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					_, wErr := w.Write([]byte(err.Error()))
-					if wErr != nil {
-						fmt.Println(errors.Join(err, wErr).Error())
-					}
-					return
-				}
+	// responded tells that ProcessMetric has already written the reason of failure
+	responded := false
+	process := func(tx *sql.Tx) error {
+		for _, metric := range metrics {
+			err := ProcessMetric(w, request, &metric, tx)
+			if err != nil {
+				responded = !errors.Is(err, errStorage)
+				return err
 			}
-			return
 		}
+		return nil
 	}
+	var err error
 	if !((PsqlInfo == nil) || (*PsqlInfo == "")) {
-		f := func() error {
-			err := tx.Commit()
-			return err
-		}
-		err = general.RetryCode(f, syscall.ECONNREFUSED)
-		if err != nil {
+		err = InTx(request.Context(), process)
+	} else {
+		err = process(nil)
+	}
+	if err != nil {
+		if !responded {
 			w.WriteHeader(http.StatusInternalServerError)
 			_, wErr := w.Write([]byte(err.Error()))
 			if wErr != nil {
 				fmt.Println(errors.Join(err, wErr).Error())
 			}
-			return
 		}
+		return
 	}
 	for _, metric := range metrics {
-		val := OurStorage.Get(metric.ID, request)
+		val := OurStorage.Get(metric.Key(), request)
 		if val != nil {
 			results = append(results, *val)
 		}
@@ -350,10 +362,10 @@ func MainPage(w http.ResponseWriter, request *http.Request) {
 	}
 	for _, k := range metrics {
 		if k.MType == agent.GAUGE {
-			ul += fmt.Sprintf("<li>%v: %v</li>", k.ID, *k.Value)
+			ul += fmt.Sprintf("<li>%v: %v</li>", k.Key(), *k.Value)
 		}
 		if k.MType == agent.COUNTER {
-			ul += fmt.Sprintf("<li>%v: %d</li>", k.ID, *k.Delta)
+			ul += fmt.Sprintf("<li>%v: %d</li>", k.Key(), *k.Delta)
 		}
 	}
 	ul += "</ul>"
@@ -387,6 +399,7 @@ func GzipHandle(next http.Handler) http.Handler {
 	})
 }
 
+// HashHandle checks HashSHA256 sign of body as it has been received, i.e. before decompression
 func HashHandle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if *ServerKey != "" {
@@ -428,13 +441,11 @@ func Decode(w http.ResponseWriter, request *http.Request, data []byte) ([]byte,
 		reader := bytes.NewReader(data)
 		gzreader, err := gzip.NewReader(reader)
 		if err != nil {
-			w.WriteHeader(http.StatusBadGateway)
 			fmt.Println(err.Error())
 			return nil, err
 		}
 		data, err = io.ReadAll(gzreader)
 		if err != nil {
-			w.WriteHeader(http.StatusBadGateway)
 			fmt.Println(err.Error())
 			return nil, err
 		}
@@ -465,7 +476,7 @@ func GetMetricShortForm(w http.ResponseWriter, request *http.Request) {
 		return
 	}
 	json.Unmarshal(data, &metric)
-	MetricName := metric.ID
+	MetricName := metric.Key()
 	MetricType := metric.MType
 	var answer []byte
 	val := OurStorage.Get(MetricName, request)
@@ -503,7 +514,8 @@ func GetMetricShortForm(w http.ResponseWriter, request *http.Request) {
 }
 
 func GetMetric(w http.ResponseWriter, request *http.Request) {
-	MetricName := chi.URLParam(request, "MetricName")
+	metric := general.Metrics{ID: chi.URLParam(request, "MetricName"), Labels: queryLabels(request)}
+	MetricName := metric.Key()
 	MetricType := chi.URLParam(request, "MetricType")
 	var answer string
 	val := OurStorage.Get(MetricName, request)
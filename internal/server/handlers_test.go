@@ -3,21 +3,27 @@ package server
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
+	"runtime"
+	"sync/atomic"
 	"testing"
 
 	"github.com/akashipov/MetricCollector/internal/agent"
 	"github.com/akashipov/MetricCollector/internal/general"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -914,3 +920,294 @@ func TestGetMetricFull(t *testing.T) {
 		})
 	}
 }
+
+func TestPing(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	key := ""
+	ServerKey = &key
+	server := httptest.NewServer(ServerRouter(&s))
+	defer server.Close()
+	// there is no database, metrics are kept in memory
+	resp, err := resty.New().R().Get(server.URL + "/ping")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+}
+
+func TestFailbackWithKey(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	key := "secret"
+	ServerKey = &key
+	defer func() {
+		key = ""
+	}()
+	defer OurStorage.Clean()
+	var primaryIsDown atomic.Bool
+	primaryIsDown.Store(true)
+	router := ServerRouter(&s)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if primaryIsDown.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secondary.Close()
+	servers, err := agent.NewServerPool([]string{primary.URL, secondary.URL}, false, 0)
+	require.NoError(t, err)
+	sender := agent.MetricSender{Client: resty.New(), Servers: servers, Key: key}
+	delta := int64(3)
+	metrics := []general.Metrics{{ID: "PollCount", MType: agent.COUNTER, Delta: &delta}}
+	require.NoError(t, sender.SendMetrics(metrics))
+	assert.Equal(t, secondary.URL, servers.Active())
+
+	// signed ping passes the sign check, so the agent switches back to the primary server
+	primaryIsDown.Store(false)
+	servers.Probe(sender.Ping)
+	assert.Equal(t, primary.URL, servers.Active())
+	require.NoError(t, sender.SendMetrics(metrics))
+	assert.Equal(t, int64(3), *OurStorage.Get("PollCount", nil).Delta)
+}
+
+func TestAgentCompressedRequests(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	var encoding string
+	router := ServerRouter(&s)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer func() {
+		key := ""
+		ServerKey = &key
+	}()
+	tests := []struct {
+		name         string
+		key          string
+		level        int
+		minSize      int
+		wantEncoding string
+	}{
+		{name: "compressed_and_signed", key: "secret", level: gzip.BestSpeed, wantEncoding: "gzip"},
+		{name: "compressed_best", key: "", level: gzip.BestCompression, wantEncoding: "gzip"},
+		{name: "small_body", key: "secret", level: gzip.BestSpeed, minSize: 1024 * 1024, wantEncoding: ""},
+		{name: "disabled", key: "secret", level: 0, wantEncoding: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			ServerKey = &key
+			sender := agent.MetricSender{
+				URL:         server.URL,
+				Client:      resty.New(),
+				Key:         key,
+				GzipLevel:   tt.level,
+				GzipMinSize: tt.minSize,
+			}
+			delta := int64(3)
+			value := 1.5
+			err := sender.SendMetrics([]general.Metrics{
+				{ID: "Compressed", MType: agent.COUNTER, Delta: &delta},
+				{ID: "CompressedGauge", MType: agent.GAUGE, Value: &value},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantEncoding, encoding)
+			assert.Equal(t, int64(3), *OurStorage.Get("Compressed", nil).Delta)
+			assert.Equal(t, 1.5, *OurStorage.Get("CompressedGauge", nil).Value)
+
+			// the same body with the wrong key is rejected
+			sender.Key = "wrong"
+			err = sender.SendMetrics([]general.Metrics{{ID: "Compressed", MType: agent.COUNTER, Delta: &delta}})
+			if tt.key != "" {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, OurStorage.Clean())
+		})
+	}
+}
+
+func TestLabeledMetrics(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	key := ""
+	ServerKey = &key
+	server := httptest.NewServer(ServerRouter(&s))
+	defer server.Close()
+	defer OurStorage.Clean()
+	// two hosts report metrics with the same IDs
+	for i, host := range []string{"web1", "web2"} {
+		sender := agent.MetricSender{URL: server.URL, Client: resty.New()}
+		labels := map[string]string{"host": host, "dc": "eu"}
+		delta := int64(i + 1)
+		value := float64(10 * (i + 1))
+		for j := 0; j < 2; j++ {
+			err := sender.SendMetrics([]general.Metrics{
+				{ID: "PollCount", MType: agent.COUNTER, Delta: &delta, Labels: labels},
+				{ID: "Alloc", MType: agent.GAUGE, Value: &value, Labels: labels},
+			})
+			require.NoError(t, err)
+		}
+	}
+	// metric without labels is kept separately too
+	resp, err := resty.New().R().Post(server.URL + "/update/counter/PollCount/7/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	assert.Equal(t, int64(2), *OurStorage.Get("PollCount{dc=eu,host=web1}", nil).Delta)
+	assert.Equal(t, int64(4), *OurStorage.Get("PollCount{dc=eu,host=web2}", nil).Delta)
+	assert.Equal(t, int64(7), *OurStorage.Get("PollCount", nil).Delta)
+	assert.Equal(t, 20.0, *OurStorage.Get("Alloc{dc=eu,host=web2}", nil).Value)
+
+	resp, err = resty.New().R().Get(server.URL + "/value/counter/PollCount?label.host=web2&label.dc=eu")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "4", string(resp.Body()))
+
+	resp, err = resty.New().R().Get(server.URL + "/value/counter/PollCount?label.host=web3&label.dc=eu")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// parameters which are not labels do not change the metric
+	resp, err = resty.New().R().Get(server.URL + "/value/counter/PollCount?label.host=web2&label.dc=eu&nocache=1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "4", string(resp.Body()))
+
+	resp, err = resty.New().R().Post(server.URL + "/update/counter/PollCount/1/?nocache=1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, int64(8), *OurStorage.Get("PollCount", nil).Delta)
+
+	resp, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"id":"Alloc","type":"gauge","labels":{"host":"web1","dc":"eu"}}`).
+		Post(server.URL + "/value/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var metric general.Metrics
+	require.NoError(t, json.Unmarshal(resp.Body(), &metric))
+	assert.Equal(t, 10.0, *metric.Value)
+	assert.Equal(t, map[string]string{"host": "web1", "dc": "eu"}, metric.Labels)
+
+	resp, err = resty.New().R().Get(server.URL + "/")
+	require.NoError(t, err)
+	assert.Contains(t, string(resp.Body()), "PollCount{dc=eu,host=web1}: 2")
+}
+
+func TestRuntimeCounters(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	key := ""
+	ServerKey = &key
+	server := httptest.NewServer(ServerRouter(&s))
+	defer server.Close()
+	defer OurStorage.Clean()
+	// gauge reported by the agent of the previous version is replaced by counter
+	resp, err := resty.New().R().Post(server.URL + "/update/gauge/NumGC/100/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	sender := agent.MetricSender{URL: server.URL, Client: resty.New()}
+	fields := []string{"NumGC", "TotalAlloc"}
+	want := make(map[string]int64)
+	report := func(c agent.Collector) {
+		metrics, err := c.Collect(context.Background())
+		require.NoError(t, err)
+		for _, m := range metrics {
+			if m.MType == agent.COUNTER {
+				want[m.ID] += *m.Delta
+			}
+		}
+		require.NoError(t, sender.SendMetrics(metrics))
+	}
+	c := agent.NewRuntimeCollector(fields)
+	report(c)
+	runtime.GC()
+	report(c)
+	// restarted agent reports increase since its start, it is added to the stored value
+	runtime.GC()
+	report(agent.NewRuntimeCollector(fields))
+	for _, id := range fields {
+		metric := OurStorage.Get(id, nil)
+		require.NotNil(t, metric, id)
+		assert.Equal(t, agent.COUNTER, metric.MType, id)
+		assert.Equal(t, want[id], *metric.Delta, id)
+	}
+}
+
+func TestDiskMetrics(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	key := ""
+	ServerKey = &key
+	server := httptest.NewServer(ServerRouter(&s))
+	defer server.Close()
+	defer OurStorage.Clean()
+	metrics, err := agent.NewDiskCollector(agent.NewFilter("", ""), agent.NewFilter("", "")).Collect(context.Background())
+	if len(metrics) == 0 {
+		t.Skipf("there are no filesystems to report: %v", err)
+	}
+	sender := agent.MetricSender{
+		URL:    server.URL,
+		Client: resty.New(),
+		Buffer: agent.NewMetricBuffer(),
+		Labels: map[string]string{"host": "web1"},
+	}
+	sender.Buffer.Add(metrics...)
+	require.NoError(t, sender.SendMetrics(sender.PrepareBatch()))
+	// mountpoints are passed as labels, so metrics can be read by URL
+	for _, m := range metrics {
+		mount := m.Labels[agent.DiskMountLabel]
+		if m.MType != agent.GAUGE || mount == "" {
+			continue
+		}
+		query := url.Values{"label.host": {"web1"}, "label." + agent.DiskMountLabel: {mount}}
+		resp, err := resty.New().R().Get(server.URL + "/value/gauge/" + m.ID + "?" + query.Encode())
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode(), m.Key())
+		assert.Equal(t, fmt.Sprintf("%v", *m.Value), string(resp.Body()), m.Key())
+	}
+}
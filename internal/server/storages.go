@@ -3,21 +3,24 @@ package server
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"syscall"
 
 	"github.com/akashipov/MetricCollector/internal/general"
 )
 
+// Storage keeps metrics by their keys, see general.Metrics.Key,
+// so metrics with the same ID and different labels are kept separately
 type Storage interface {
-	Get(metricName string, request *http.Request) *general.Metrics
+	Get(key string, request *http.Request) *general.Metrics
 	GetAll() (map[string]*general.Metrics, error)
+	// Record saves metric in transaction tx if it is set
 	Record(
 		value *general.Metrics, request *http.Request,
 		tx *sql.Tx,
-	)
+	) error
 	Clean() error
 }
 
@@ -25,16 +28,24 @@ type PsqlStorage struct {
 	PsqlInfo *string
 }
 
-func (r *PsqlStorage) Get(metricName string, request *http.Request) *general.Metrics {
+// metricColumns are columns of metrics table in order they are scanned,
+// id column keeps key of metric, name column keeps its ID
+const metricColumns = "name, mtype, value, delta, labels"
+
+func (r *PsqlStorage) Get(key string, request *http.Request) *general.Metrics {
 	if (r.PsqlInfo == nil) || (*r.PsqlInfo == "") {
 		fmt.Printf("Wrong settings for class PsqlInfo: '%s'\n", *r.PsqlInfo)
 		return nil
 	}
-	row := DB.QueryRowContext(request.Context(), "SELECT * FROM metrics WHERE id = $1", metricName)
+	row := DB.QueryRowContext(request.Context(), "SELECT "+metricColumns+" FROM metrics WHERE id = $1", key)
 	var metric general.Metrics
 	var v sql.NullFloat64
 	var delta sql.NullInt64
-	err := row.Scan(&metric.ID, &metric.MType, &v, &delta)
+	var labels sql.NullString
+	err := row.Scan(&metric.ID, &metric.MType, &v, &delta, &labels)
+	if err == nil {
+		metric.Labels, err = decodeLabels(labels)
+	}
 	if v.Valid {
 		metric.Value = &v.Float64
 		metric.Delta = nil
@@ -54,7 +65,7 @@ func (r *PsqlStorage) GetAll() (map[string]*general.Metrics, error) {
 		err := fmt.Errorf("wrong settings for class PsqlInfo: '%s'", *r.PsqlInfo)
 		return nil, err
 	}
-	rows, err := DB.QueryContext(context.Background(), "SELECT * FROM metrics")
+	rows, err := DB.QueryContext(context.Background(), "SELECT "+metricColumns+" FROM metrics")
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +76,11 @@ func (r *PsqlStorage) GetAll() (map[string]*general.Metrics, error) {
 		var metric general.Metrics
 		var v sql.NullFloat64
 		var delta sql.NullInt64
-		err := rows.Scan(&metric.ID, &metric.MType, &v, &delta)
+		var labels sql.NullString
+		err := rows.Scan(&metric.ID, &metric.MType, &v, &delta, &labels)
+		if err == nil {
+			metric.Labels, err = decodeLabels(labels)
+		}
 		if err != nil {
 			rErr = errors.Join(rErr, err)
 		}
@@ -77,7 +92,7 @@ func (r *PsqlStorage) GetAll() (map[string]*general.Metrics, error) {
 			metric.Delta = &delta.Int64
 			metric.Value = nil
 		}
-		metrics[metric.ID] = &metric
+		metrics[metric.Key()] = &metric
 	}
 	if rErr != nil {
 		return nil, rErr
@@ -100,13 +115,14 @@ func (r *PsqlStorage) Clean() error {
 	return nil
 }
 
+// Record saves metric in tx once, the whole transaction is repeated by InTx on failure,
+// statements without transaction are repeated by DBRetry
 func (r *PsqlStorage) Record(
 	value *general.Metrics, request *http.Request,
 	tx *sql.Tx,
-) {
+) error {
 	if (r.PsqlInfo == nil) || (*r.PsqlInfo == "") {
-		fmt.Printf("Wrong settings for class PsqlInfo: '%s'\n", *r.PsqlInfo)
-		return
+		return fmt.Errorf("wrong settings for class PsqlInfo: '%s'", *r.PsqlInfo)
 	}
 	var v sql.NullFloat64
 	var delta sql.NullInt64
@@ -124,41 +140,59 @@ func (r *PsqlStorage) Record(
 		v.Float64 = 0.0
 		v.Valid = false
 	}
-	query := "INSERT INTO metrics VALUES($1, $2, $3, $4) ON CONFLICT (id) DO UPDATE SET mtype = $2, value = $3, delta = $4;"
+	labels, err := encodeLabels(value.Labels)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO metrics (id, name, mtype, value, delta, labels) VALUES($1, $2, $3, $4, $5, $6) " +
+		"ON CONFLICT (id) DO UPDATE SET mtype = $3, value = $4, delta = $5;"
 	if tx != nil {
-		f := func() error {
-			_, err := tx.ExecContext(
-				request.Context(),
-				query, value.ID, value.MType, v, delta,
-			)
-			return err
-		}
-		err := general.RetryCode(f, syscall.ECONNREFUSED)
-		if err != nil {
-			fmt.Println(err.Error())
-			tx.Rollback()
-		}
-	} else {
-		f := func() error {
-			_, err := DB.ExecContext(
-				request.Context(),
-				query, value.ID, value.MType, v, delta,
-			)
-			return err
-		}
-		err := general.RetryCode(f, syscall.ECONNREFUSED)
-		if err != nil {
-			fmt.Println(err.Error())
-		}
+		_, err = tx.ExecContext(
+			request.Context(),
+			query, value.Key(), value.ID, value.MType, v, delta, labels,
+		)
+		return err
+	}
+	f := func(ctx context.Context) error {
+		_, err := DB.ExecContext(
+			ctx,
+			query, value.Key(), value.ID, value.MType, v, delta, labels,
+		)
+		return err
+	}
+	return DBRetry.Do(request.Context(), f)
+}
+
+// encodeLabels returns labels as JSON object, it is NULL if there are no labels
+func encodeLabels(labels map[string]string) (sql.NullString, error) {
+	if len(labels) == 0 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return sql.NullString{}, err
 	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func decodeLabels(labels sql.NullString) (map[string]string, error) {
+	if !labels.Valid || labels.String == "" {
+		return nil, nil
+	}
+	var result map[string]string
+	err := json.Unmarshal([]byte(labels.String), &result)
+	if err != nil {
+		return nil, fmt.Errorf("wrong labels '%s': %w", labels.String, err)
+	}
+	return result, nil
 }
 
 type MemStorage struct {
 	MetricList map[string]*general.Metrics `json:"metrics"`
 }
 
-func (r *MemStorage) Get(metricName string, request *http.Request) *general.Metrics {
-	val, ok := r.MetricList[metricName]
+func (r *MemStorage) Get(key string, request *http.Request) *general.Metrics {
+	val, ok := r.MetricList[key]
 	if ok {
 		return val
 	}
@@ -177,17 +211,18 @@ func (r *MemStorage) Clean() error {
 func (r *MemStorage) Record(
 	value *general.Metrics, request *http.Request,
 	tx *sql.Tx,
-) {
-	r.MetricList[value.ID] = value
+) error {
+	r.MetricList[value.Key()] = value
+	return nil
 }
 
 func (r *MemStorage) String() string {
 	s := ""
 	for _, v := range r.MetricList {
 		if v.Delta != nil {
-			s += fmt.Sprintf("key: %s -> value: %d\n", v.ID, *v.Delta)
+			s += fmt.Sprintf("key: %s -> value: %d\n", v.Key(), *v.Delta)
 		} else {
-			s += fmt.Sprintf("key: %s -> value: %f\n", v.ID, *v.Value)
+			s += fmt.Sprintf("key: %s -> value: %f\n", v.Key(), *v.Value)
 		}
 	}
 	return s
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedNet is a subnet of agents allowed to update and read metrics, all are allowed if it is nil
+var TrustedNet *net.IPNet
+
+// trustedPaths are prefixes of paths which are checked against TrustedNet
+var trustedPaths = []string{"/update/", "/updates/", "/value/"}
+
+// LoadTrustedSubnet parses TrustedSubnet CIDR if it is set
+func LoadTrustedSubnet() error {
+	TrustedNet = nil
+	if TrustedSubnet == nil || *TrustedSubnet == "" {
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(*TrustedSubnet))
+	if err != nil {
+		return fmt.Errorf("wrong trusted subnet: %w", err)
+	}
+	TrustedNet = ipNet
+	return nil
+}
+
+// TrustedSubnetHandle rejects requests to update and read metrics with 403
+// if X-Real-IP of the agent is absent or is out of TrustedNet
+func TrustedSubnetHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if TrustedNet == nil || !isTrustedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		v := r.Header.Get("X-Real-IP")
+		ip := net.ParseIP(strings.TrimSpace(v))
+		if ip == nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("X-Real-IP is absent or wrong: '%s'", v)))
+			return
+		}
+		if !TrustedNet.Contains(ip) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("Address %s is not in trusted subnet", ip)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isTrustedPath(path string) bool {
+	// paths are checked with trailing slash, so '/update' and '/updates' match too
+	path = strings.TrimRight(path, "/") + "/"
+	for _, prefix := range trustedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
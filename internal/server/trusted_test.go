@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akashipov/MetricCollector/internal/agent"
+	"github.com/akashipov/MetricCollector/internal/general"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestTrustedSubnetHandle(t *testing.T) {
+	saved := TrustedSubnet
+	defer func() {
+		TrustedSubnet = saved
+		require.NoError(t, LoadTrustedSubnet())
+	}()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name       string
+		subnet     string
+		path       string
+		realIP     string
+		wantStatus int
+	}{
+		{name: "no_subnet", subnet: "", path: "/updates/", realIP: "", wantStatus: http.StatusOK},
+		{name: "ipv4_inside", subnet: "192.168.1.0/24", path: "/updates/", realIP: "192.168.1.17", wantStatus: http.StatusOK},
+		{name: "ipv4_outside", subnet: "192.168.1.0/24", path: "/updates/", realIP: "192.168.2.17", wantStatus: http.StatusForbidden},
+		{name: "ipv4_update", subnet: "10.0.0.0/8", path: "/update/counter/A/1", realIP: "11.0.0.1", wantStatus: http.StatusForbidden},
+		{name: "ipv4_value", subnet: "10.0.0.0/8", path: "/value/", realIP: "10.20.30.40", wantStatus: http.StatusOK},
+		{name: "ipv6_inside", subnet: "fd00:1::/64", path: "/updates/", realIP: "fd00:1::abcd", wantStatus: http.StatusOK},
+		{name: "ipv6_outside", subnet: "fd00:1::/64", path: "/value/gauge/A", realIP: "fd00:2::abcd", wantStatus: http.StatusForbidden},
+		{name: "ipv4_in_ipv6_subnet", subnet: "fd00:1::/64", path: "/updates/", realIP: "10.0.0.1", wantStatus: http.StatusForbidden},
+		{name: "absent_header", subnet: "10.0.0.0/8", path: "/updates/", realIP: "", wantStatus: http.StatusForbidden},
+		{name: "wrong_header", subnet: "10.0.0.0/8", path: "/updates/", realIP: "10.0.0", wantStatus: http.StatusForbidden},
+		{name: "not_checked_path", subnet: "10.0.0.0/8", path: "/ping", realIP: "", wantStatus: http.StatusOK},
+		{name: "not_checked_main_page", subnet: "10.0.0.0/8", path: "/", realIP: "11.0.0.1", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			TrustedSubnet = &tt.subnet
+			require.NoError(t, LoadTrustedSubnet())
+			request := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			TrustedSubnetHandle(next).ServeHTTP(w, request)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+	wrong := "10.0.0.0/33"
+	TrustedSubnet = &wrong
+	assert.Error(t, LoadTrustedSubnet())
+}
+
+func TestTrustedSubnet_Agent(t *testing.T) {
+	InitDB()
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer logger.Sync()
+	s := *logger.Sugar()
+	key := ""
+	ServerKey = &key
+	saved := TrustedSubnet
+	defer func() {
+		TrustedSubnet = saved
+		require.NoError(t, LoadTrustedSubnet())
+	}()
+	server := httptest.NewServer(ServerRouter(&s))
+	defer server.Close()
+	for _, tt := range []struct {
+		subnet     string
+		wantStatus int
+	}{
+		{subnet: "127.0.0.0/8"},
+		{subnet: "10.0.0.0/8", wantStatus: http.StatusForbidden},
+	} {
+		t.Run(tt.subnet, func(t *testing.T) {
+			TrustedSubnet = &tt.subnet
+			require.NoError(t, LoadTrustedSubnet())
+			sender := agent.MetricSender{URL: server.URL, Client: resty.New()}
+			delta := int64(1)
+			err := sender.SendMetrics([]general.Metrics{{ID: "Trusted", MType: agent.COUNTER, Delta: &delta}})
+			if tt.wantStatus == 0 {
+				assert.NoError(t, err)
+				assert.NoError(t, OurStorage.Clean())
+				return
+			}
+			var statusErr *general.HTTPStatusError
+			require.True(t, errors.As(err, &statusErr))
+			assert.Equal(t, tt.wantStatus, statusErr.StatusCode)
+		})
+	}
+}
+
+func TestResolveTrustedSubnet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"trusted_subnet": "10.0.0.0/8", "address": ":8080"}`), 0o600))
+	file, err := loadServerFileConfig(path)
+	require.NoError(t, err)
+	env := "192.168.0.0/16"
+	tests := []struct {
+		name      string
+		flagValue string
+		explicit  bool
+		env       *string
+		file      ServerFileConfig
+		want      string
+	}{
+		{name: "flag", flagValue: "172.16.0.0/12", explicit: true, env: &env, file: file, want: "172.16.0.0/12"},
+		{name: "empty_flag", flagValue: "", explicit: true, env: &env, file: file, want: ""},
+		{name: "env", env: &env, file: file, want: "192.168.0.0/16"},
+		{name: "file", file: file, want: "10.0.0.0/8"},
+		{name: "default", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resolveTrustedSubnet(tt.flagValue, tt.explicit, tt.env, tt.file))
+		})
+	}
+
+	file, err = loadServerFileConfig("")
+	require.NoError(t, err)
+	assert.Nil(t, file.TrustedSubnet)
+	require.NoError(t, os.WriteFile(path, []byte(`{"trusted_subnet": 1}`), 0o600))
+	_, err = loadServerFileConfig(path)
+	assert.Error(t, err)
+	_, err = loadServerFileConfig(filepath.Join(t.TempDir(), "absent.json"))
+	assert.Error(t, err)
+}